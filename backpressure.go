@@ -0,0 +1,218 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OverflowStrategy 決定 AudioQueue 滿了之後要怎麼處理新進來的 frame。
+type OverflowStrategy string
+
+const (
+	StrategyDropOldest       OverflowStrategy = "drop-oldest" // 舊行為：丟掉排最久的 frame
+	StrategyDropNewest       OverflowStrategy = "drop-newest"
+	StrategyCoalesce         OverflowStrategy = "coalesce" // 把待送的 PCM 接起來變成一個更大的 frame
+	StrategyBlockWithTimeout OverflowStrategy = "block-with-timeout"
+
+	defaultAudioQueueCapacity = 4 // ~80ms audio buffer，維持原本的預設值
+	defaultBlockTimeout       = 200 * time.Millisecond
+
+	// sustainedOverflowStreak 是連續幾次 overflow 才判定為「持續壅塞」，避免單一個 burst 就誤觸發。
+	sustainedOverflowStreak = 8
+)
+
+func audioQueueCapacityFromEnv() int {
+	if v, err := strconv.Atoi(os.Getenv("AUDIO_QUEUE_CAPACITY")); err == nil && v > 0 {
+		return v
+	}
+	return defaultAudioQueueCapacity
+}
+
+func overflowStrategyFromEnv() OverflowStrategy {
+	switch OverflowStrategy(os.Getenv("AUDIO_OVERFLOW_STRATEGY")) {
+	case StrategyDropNewest:
+		return StrategyDropNewest
+	case StrategyCoalesce:
+		return StrategyCoalesce
+	case StrategyBlockWithTimeout:
+		return StrategyBlockWithTimeout
+	default:
+		return StrategyDropOldest
+	}
+}
+
+func blockTimeoutFromEnv() time.Duration {
+	if v, err := strconv.Atoi(os.Getenv("AUDIO_BLOCK_TIMEOUT_MS")); err == nil && v > 0 {
+		return time.Duration(v) * time.Millisecond
+	}
+	return defaultBlockTimeout
+}
+
+// backpressureNotifier 是 provider 可選擇實作的能力：讓 WSWriter 的 AudioQueue
+// 在偵測到持續壅塞時，能通知上層（main.go）送一個 x-realtime-backpressure
+// control event 給 client，讓前端自己降 mic gain 或降 sample rate。
+type backpressureNotifier interface {
+	OnBackpressure(func(sustained bool))
+}
+
+// AudioQueue 是 WSWriter 內部有界的音訊佇列。滿了之後依 strategy 處理 overflow，
+// 並在連續 overflow 達到門檻時透過 onSustained 通知一次（回到正常後再通知一次解除）。
+type AudioQueue struct {
+	items        chan []byte
+	strategy     OverflowStrategy
+	blockTimeout time.Duration
+	lowWatermark int
+
+	mu             sync.Mutex
+	overflowStreak int
+	sustained      bool
+	onSustained    func(sustained bool)
+}
+
+func newAudioQueue(capacity int, strategy OverflowStrategy, blockTimeout time.Duration, onSustained func(bool)) *AudioQueue {
+	if capacity <= 0 {
+		capacity = defaultAudioQueueCapacity
+	}
+	lowWatermark := capacity / 2
+	if lowWatermark < 1 {
+		lowWatermark = 1
+	}
+	return &AudioQueue{
+		items:        make(chan []byte, capacity),
+		strategy:     strategy,
+		blockTimeout: blockTimeout,
+		lowWatermark: lowWatermark,
+		onSustained:  onSustained,
+	}
+}
+
+// Push 嘗試把一個 PCM frame 放進佇列；滿了就依 strategy 處理 overflow 並記錄 metrics。
+func (q *AudioQueue) Push(pcm []byte) {
+	select {
+	case q.items <- pcm:
+		q.recordSuccess()
+		return
+	default:
+	}
+
+	switch q.strategy {
+	case StrategyDropNewest:
+		audioQueueMetrics.framesDropped.WithLabelValues(string(StrategyDropNewest)).Inc()
+
+	case StrategyCoalesce:
+		q.pushCoalesce(pcm)
+
+	case StrategyBlockWithTimeout:
+		start := time.Now()
+		select {
+		case q.items <- pcm:
+		case <-time.After(q.blockTimeout):
+			audioQueueMetrics.framesDropped.WithLabelValues(string(StrategyBlockWithTimeout)).Inc()
+		}
+		audioQueueMetrics.writerStallSeconds.Observe(time.Since(start).Seconds())
+
+	default: // StrategyDropOldest
+		select {
+		case <-q.items:
+			audioQueueMetrics.framesDropped.WithLabelValues(string(StrategyDropOldest)).Inc()
+		default:
+		}
+		select {
+		case q.items <- pcm:
+		default:
+			audioQueueMetrics.framesDropped.WithLabelValues(string(StrategyDropOldest)).Inc()
+		}
+	}
+
+	q.recordOverflow()
+}
+
+// pushCoalesce 把佇列裡所有待送的 frame 依序跟新來的 frame 接起來，變成一個 frame 放回
+// 已清空的佇列，順序不變；資訊不會真的丟失，只是會晚一點、包成一個較大的
+// input_audio_buffer.append 送出去。
+func (q *AudioQueue) pushCoalesce(pcm []byte) {
+	var merged []byte
+	drained := false
+drain:
+	for {
+		select {
+		case old := <-q.items:
+			merged = append(merged, old...)
+			drained = true
+		default:
+			break drain
+		}
+	}
+	merged = append(merged, pcm...)
+	if drained {
+		audioQueueMetrics.coalescedBytes.Add(float64(len(merged)))
+	}
+
+	select {
+	case q.items <- merged:
+	default:
+		audioQueueMetrics.framesDropped.WithLabelValues(string(StrategyCoalesce)).Inc()
+	}
+}
+
+func (q *AudioQueue) recordOverflow() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.overflowStreak++
+	if !q.sustained && q.overflowStreak >= sustainedOverflowStreak {
+		q.sustained = true
+		log.Printf("⚠️ sustained audio backpressure detected (strategy=%s)\n", q.strategy)
+		if q.onSustained != nil {
+			q.onSustained(true)
+		}
+	}
+}
+
+func (q *AudioQueue) recordSuccess() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.overflowStreak = 0
+	if q.sustained && len(q.items) <= q.lowWatermark {
+		q.sustained = false
+		if q.onSustained != nil {
+			q.onSustained(false)
+		}
+	}
+}
+
+// ---- Prometheus metrics ----
+
+type audioQueueMetricsRegistry struct {
+	framesDropped      *prometheus.CounterVec
+	coalescedBytes     prometheus.Counter
+	writerStallSeconds prometheus.Histogram
+}
+
+var audioQueueMetrics = newAudioQueueMetrics()
+
+func newAudioQueueMetrics() *audioQueueMetricsRegistry {
+	m := &audioQueueMetricsRegistry{
+		framesDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "realtime_proxy_audio_frames_dropped_total",
+			Help: "Audio frames dropped by the backpressure-aware output queue, by strategy.",
+		}, []string{"strategy"}),
+		coalescedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "realtime_proxy_audio_coalesced_bytes_total",
+			Help: "Bytes produced by coalescing pending PCM frames under the coalesce strategy.",
+		}),
+		writerStallSeconds: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "realtime_proxy_audio_writer_stall_seconds",
+			Help:    "Time SendAudio blocked waiting for queue space under block-with-timeout.",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+	prometheus.MustRegister(m.framesDropped, m.coalescedBytes, m.writerStallSeconds)
+	return m
+}
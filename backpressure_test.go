@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func drainAudioQueue(q *AudioQueue) [][]byte {
+	var out [][]byte
+	for {
+		select {
+		case b := <-q.items:
+			out = append(out, b)
+		default:
+			return out
+		}
+	}
+}
+
+func TestAudioQueue_DropOldestKeepsNewestFrames(t *testing.T) {
+	q := newAudioQueue(2, StrategyDropOldest, 0, nil)
+	q.Push([]byte("a"))
+	q.Push([]byte("b"))
+	q.Push([]byte("c")) // queue full, "a" should be dropped to make room
+
+	got := drainAudioQueue(q)
+	if len(got) != 2 || string(got[0]) != "b" || string(got[1]) != "c" {
+		t.Fatalf("want [b c], got %v", got)
+	}
+}
+
+func TestAudioQueue_DropNewestDiscardsIncomingFrame(t *testing.T) {
+	q := newAudioQueue(1, StrategyDropNewest, 0, nil)
+	q.Push([]byte("a"))
+	q.Push([]byte("b")) // queue full, "b" should just be dropped
+
+	got := drainAudioQueue(q)
+	if len(got) != 1 || string(got[0]) != "a" {
+		t.Fatalf("want [a], got %v", got)
+	}
+}
+
+func TestAudioQueue_CoalesceMergesPendingFrame(t *testing.T) {
+	q := newAudioQueue(1, StrategyCoalesce, 0, nil)
+	q.Push([]byte{0x01, 0x02})
+	q.Push([]byte{0x03, 0x04}) // queue full, should merge with the pending frame
+
+	got := drainAudioQueue(q)
+	if len(got) != 1 {
+		t.Fatalf("want exactly 1 merged frame, got %d", len(got))
+	}
+	want := []byte{0x01, 0x02, 0x03, 0x04}
+	if string(got[0]) != string(want) {
+		t.Fatalf("want merged frame %v, got %v", want, got[0])
+	}
+}
+
+func TestAudioQueue_BlockWithTimeoutDropsAfterDeadline(t *testing.T) {
+	timeout := 20 * time.Millisecond
+	q := newAudioQueue(1, StrategyBlockWithTimeout, timeout, nil)
+	q.Push([]byte("a")) // fills the only slot
+
+	start := time.Now()
+	q.Push([]byte("b")) // nothing drains the queue, so this should block then drop
+	if elapsed := time.Since(start); elapsed < timeout {
+		t.Fatalf("want Push to block for at least %s, only blocked %s", timeout, elapsed)
+	}
+
+	got := drainAudioQueue(q)
+	if len(got) != 1 || string(got[0]) != "a" {
+		t.Fatalf("want [a] left in queue, got %v", got)
+	}
+}
+
+func TestAudioQueue_SustainedBackpressureNotifiesOnceEachWay(t *testing.T) {
+	var calls []bool
+	q := newAudioQueue(2, StrategyDropOldest, 0, func(sustained bool) {
+		calls = append(calls, sustained)
+	})
+
+	q.Push([]byte("a"))
+	q.Push([]byte("b")) // fills the queue without overflowing
+
+	for i := 0; i < sustainedOverflowStreak; i++ {
+		q.Push([]byte{byte(i)})
+	}
+	if len(calls) != 1 || calls[0] != true {
+		t.Fatalf("want a single sustained=true notification after %d overflows, got %v", sustainedOverflowStreak, calls)
+	}
+
+	drainAudioQueue(q)
+	q.Push([]byte("recovered")) // queue back down to lowWatermark, should clear sustained
+
+	if len(calls) != 2 || calls[1] != false {
+		t.Fatalf("want a sustained=false notification once the queue drains, got %v", calls)
+	}
+}
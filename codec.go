@@ -0,0 +1,80 @@
+package main
+
+import "fmt"
+
+const (
+	CodecPCM  = "pcm"
+	CodecOpus = "opus"
+
+	// opusFrameMs 是 Opus 編碼慣用的封包長度；20ms 剛好對應 hraban/opus 預設的 frame size。
+	opusFrameMs = 20
+	// pcmFrameBytes 是 rateHz/ch 下 20ms 的 PCM16 bytes 數，framer 用它切齊輸出。
+	pcmFrameBytes = rateHz * ch * 2 * opusFrameMs / 1000
+
+	// ?container= 決定 codec=opus 的每個 WS binary message 裡面裝的是什麼：
+	//   raw  - 一個 message = 一個裸 Opus packet（自己做 framing 的 client，例如原生 app）
+	//   ogg  - 一個 message = 一個 Ogg page（Firefox MediaRecorder audio/ogg;codecs=opus）
+	//   webm - 一個 message = 一個 WebM/EBML chunk（Chrome MediaRecorder 預設的
+	//          audio/webm;codecs=opus），用 webmdemux.go 裡的極簡 EBML 解封裝抽出 SimpleBlock。
+	ContainerRaw  = "raw"
+	ContainerOgg  = "ogg"
+	ContainerWebM = "webm"
+)
+
+// AudioCodec 負責把 client 傳來的網路封包轉成 PCM16，以及把要送出去的 PCM16
+// 轉成要寫進 WS 的網路封包。拆出這層之後，/ws?codec=opus 可以省下 ~10x 頻寬，
+// 同時保留 codec=pcm（或不帶參數）的原始行為當 fallback。
+type AudioCodec interface {
+	Name() string
+	DecodeToPCM(frame []byte) ([]byte, error)
+	EncodeFromPCM(pcm []byte) ([][]byte, error)
+}
+
+// newAudioCodec 依 ?codec=、?container= 的值挑選對應的實作。
+// opus 實作在 codec_opus.go（build tag cgo），沒有 cgo 的環境會在這裡直接回錯；
+// handleClientWS 收到錯誤會記 log 並關掉連線，不會自動改用 codec=pcm。
+func newAudioCodec(name, container string) (AudioCodec, error) {
+	switch name {
+	case "", CodecPCM:
+		return pcmCodec{}, nil
+	case CodecOpus:
+		switch container {
+		case "":
+			container = ContainerRaw
+		case ContainerRaw, ContainerOgg, ContainerWebM:
+		default:
+			return nil, fmt.Errorf("unknown container: %q", container)
+		}
+		return newOpusCodec(container)
+	default:
+		return nil, fmt.Errorf("unknown codec: %q", name)
+	}
+}
+
+// pcmCodec 是 no-op codec，維持現有「client/OpenAI 都講 raw PCM16」的行為。
+type pcmCodec struct{}
+
+func (pcmCodec) Name() string                            { return CodecPCM }
+func (pcmCodec) DecodeToPCM(frame []byte) ([]byte, error) { return frame, nil }
+func (pcmCodec) EncodeFromPCM(pcm []byte) ([][]byte, error) {
+	return [][]byte{pcm}, nil
+}
+
+// pcmFramer 把不固定長度的 PCM 串流切成固定 20ms 的區塊，讓 codec 編碼和
+// pacer 送出的節奏都可預期。剩下不足一個 frame 的尾巴會留到下次一起送。
+type pcmFramer struct {
+	buf []byte
+}
+
+func (f *pcmFramer) push(pcm []byte) [][]byte {
+	f.buf = append(f.buf, pcm...)
+
+	var frames [][]byte
+	for len(f.buf) >= pcmFrameBytes {
+		frame := make([]byte, pcmFrameBytes)
+		copy(frame, f.buf[:pcmFrameBytes])
+		frames = append(frames, frame)
+		f.buf = f.buf[pcmFrameBytes:]
+	}
+	return frames
+}
@@ -0,0 +1,114 @@
+//go:build cgo
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/hraban/opus"
+)
+
+// opusCodec 用 cgo 綁的 libopus 做編解碼。沒有 cgo 工具鏈的環境（例如精簡的
+// container build）就不會編進這個檔案，codec=opus 時 newAudioCodec 會回錯，連線隨即被關掉。
+//
+// container 決定怎麼從一個 WS binary message 裡面抽出 Opus packet：
+//   - ContainerRaw：message 本身就是一個 packet。
+//   - ContainerOgg：message 是一個 Ogg page，用 oggPacketExtractor 拆出裡面的 packet。
+//   - ContainerWebM：message 是一個 WebM/EBML chunk，用 webmPacketExtractor 拆出裡面的 packet。
+type opusCodec struct {
+	dec *opus.Decoder
+	enc *opus.Encoder
+
+	framer    pcmFramer
+	container string
+	ogg       *oggPacketExtractor
+	webm      *webmPacketExtractor
+}
+
+func newOpusCodec(container string) (*opusCodec, error) {
+	dec, err := opus.NewDecoder(rateHz, ch)
+	if err != nil {
+		return nil, fmt.Errorf("opus decoder: %w", err)
+	}
+	enc, err := opus.NewEncoder(rateHz, ch, opus.AppVoIP)
+	if err != nil {
+		return nil, fmt.Errorf("opus encoder: %w", err)
+	}
+
+	c := &opusCodec{dec: dec, enc: enc, container: container}
+	switch container {
+	case ContainerOgg:
+		c.ogg = &oggPacketExtractor{}
+	case ContainerWebM:
+		c.webm = &webmPacketExtractor{}
+	}
+	return c, nil
+}
+
+func (c *opusCodec) Name() string { return CodecOpus }
+
+// DecodeToPCM 依 container 把 message 拆成一個或多個 Opus packet，解碼後串接成 PCM16。
+func (c *opusCodec) DecodeToPCM(frame []byte) ([]byte, error) {
+	switch c.container {
+	case ContainerOgg:
+		packets, err := c.ogg.extract(frame)
+		if err != nil {
+			return nil, fmt.Errorf("ogg demux: %w", err)
+		}
+		return c.decodePackets(packets)
+	case ContainerWebM:
+		packets, err := c.webm.extract(frame)
+		if err != nil {
+			return nil, fmt.Errorf("webm demux: %w", err)
+		}
+		return c.decodePackets(packets)
+	default:
+		return c.decodePacket(frame)
+	}
+}
+
+func (c *opusCodec) decodePackets(packets [][]byte) ([]byte, error) {
+	var pcm []byte
+	for _, pkt := range packets {
+		decoded, err := c.decodePacket(pkt)
+		if err != nil {
+			return nil, err
+		}
+		pcm = append(pcm, decoded...)
+	}
+	return pcm, nil
+}
+
+func (c *opusCodec) decodePacket(packet []byte) ([]byte, error) {
+	pcm := make([]int16, pcmFrameBytes/2)
+	n, err := c.dec.Decode(packet, pcm)
+	if err != nil {
+		return nil, fmt.Errorf("opus decode: %w", err)
+	}
+
+	out := make([]byte, n*ch*2)
+	for i := 0; i < n*ch; i++ {
+		binary.LittleEndian.PutUint16(out[i*2:], uint16(pcm[i]))
+	}
+	return out, nil
+}
+
+// EncodeFromPCM 先用 pcmFramer 切成 20ms 區塊，再逐一編碼成 Opus packet。
+func (c *opusCodec) EncodeFromPCM(pcm []byte) ([][]byte, error) {
+	var packets [][]byte
+	for _, frame := range c.framer.push(pcm) {
+		samples := make([]int16, len(frame)/2)
+		for i := range samples {
+			samples[i] = int16(binary.LittleEndian.Uint16(frame[i*2:]))
+		}
+
+		data := make([]byte, 4000) // 遠大於任何合理的 20ms opus packet
+		n, err := c.enc.Encode(samples, data)
+		if err != nil {
+			return nil, fmt.Errorf("opus encode: %w", err)
+		}
+		packets = append(packets, data[:n])
+	}
+	return packets, nil
+}
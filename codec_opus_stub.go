@@ -0,0 +1,11 @@
+//go:build !cgo
+
+package main
+
+import "fmt"
+
+// newOpusCodec 的 non-cgo fallback：這個 build 沒有連 libopus，
+// 呼叫端（newAudioCodec）會把這個錯誤往上丟，/ws handler 記 log 後關掉連線（不會改用 codec=pcm）。
+func newOpusCodec(container string) (AudioCodec, error) {
+	return nil, fmt.Errorf("opus codec unavailable: built without cgo")
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// sessionIdleTimeout：超過這麼久沒有任何活動（收/送）的 session 會被 reaper 關掉。
+	sessionIdleTimeout = 10 * time.Minute
+	reaperInterval     = 1 * time.Minute
+)
+
+// ClientConn 是一個已連線 client 的完整狀態：底層 WS 連線、所屬 provider、
+// 統計資訊，全部包成一個 struct 方便 Hub 管理和 admin API 查詢。
+type ClientConn struct {
+	id       string
+	conn     *websocket.Conn
+	provider RealtimeProvider
+	channel  string
+	recorder *Recorder
+
+	writeMu sync.Mutex
+
+	remoteAddr string
+	openedAt   time.Time
+
+	mu           sync.Mutex
+	bytesIn      int64
+	bytesOut     int64
+	lastActivity time.Time
+	closed       bool
+}
+
+func newClientConn(id string, conn *websocket.Conn, provider RealtimeProvider, channel string) *ClientConn {
+	now := time.Now()
+	return &ClientConn{
+		id:           id,
+		conn:         conn,
+		provider:     provider,
+		channel:      channel,
+		remoteAddr:   conn.RemoteAddr().String(),
+		openedAt:     now,
+		lastActivity: now,
+	}
+}
+
+func (c *ClientConn) touch(deltaIn, deltaOut int64) {
+	c.mu.Lock()
+	c.bytesIn += deltaIn
+	c.bytesOut += deltaOut
+	c.lastActivity = time.Now()
+	c.mu.Unlock()
+}
+
+// WriteBinary / WriteText 跟 pingLoop 一樣都要先拿 writeMu 才寫入底層 conn，確保多個 goroutine
+// （OpenAI receiver、admin broadcast、ping）不會同時寫壞同一條連線。
+func (c *ClientConn) WriteBinary(data []byte) error {
+	c.writeMu.Lock()
+	err := c.conn.WriteMessage(websocket.BinaryMessage, data)
+	c.writeMu.Unlock()
+	if err == nil {
+		c.touch(0, int64(len(data)))
+	}
+	return err
+}
+
+func (c *ClientConn) WriteText(data []byte) error {
+	c.writeMu.Lock()
+	err := c.conn.WriteMessage(websocket.TextMessage, data)
+	c.writeMu.Unlock()
+	if err == nil {
+		c.touch(0, int64(len(data)))
+	}
+	return err
+}
+
+func (c *ClientConn) isIdle(since time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Since(c.lastActivity) > since
+}
+
+// Close 關閉底層連線與背後的 provider；handleClientWS 的兩個 goroutine（client 讀迴圈、
+// provider receiver）不是靠被通知收尾的，而是靠這裡關掉的 conn/provider 讓它們卡住的
+// blocking read 回傳錯誤，進而各自 cancel() 離開——admin force-close 能動就是靠這個。
+func (c *ClientConn) Close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	if c.provider != nil {
+		_ = c.provider.Close()
+	}
+	if c.recorder != nil {
+		c.recorder.Close()
+	}
+	_ = c.conn.Close()
+}
+
+// SessionInfo 是 admin API 回傳的 session 快照。
+type SessionInfo struct {
+	ID           string    `json:"id"`
+	RemoteAddr   string    `json:"remote_addr"`
+	Channel      string    `json:"channel"`
+	OpenedAt     time.Time `json:"opened_at"`
+	LastActivity time.Time `json:"last_activity"`
+	BytesIn      int64     `json:"bytes_in"`
+	BytesOut     int64     `json:"bytes_out"`
+}
+
+// Hub 追蹤所有連線中的 ClientConn，依 channel 分組，支援 server-initiated 的
+// broadcast/unicast，並跑一個背景 reaper 把閒置太久的 session 關掉。
+type Hub struct {
+	mu       sync.Mutex
+	sessions map[string]*ClientConn
+}
+
+func newHub() *Hub {
+	h := &Hub{sessions: make(map[string]*ClientConn)}
+	go h.reapLoop()
+	return h
+}
+
+func (h *Hub) register(c *ClientConn) {
+	h.mu.Lock()
+	h.sessions[c.id] = c
+	h.mu.Unlock()
+}
+
+func (h *Hub) unregister(id string) {
+	h.mu.Lock()
+	delete(h.sessions, id)
+	h.mu.Unlock()
+}
+
+func (h *Hub) get(id string) (*ClientConn, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, ok := h.sessions[id]
+	return c, ok
+}
+
+func (h *Hub) list() []SessionInfo {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	out := make([]SessionInfo, 0, len(h.sessions))
+	for _, c := range h.sessions {
+		c.mu.Lock()
+		out = append(out, SessionInfo{
+			ID:           c.id,
+			RemoteAddr:   c.remoteAddr,
+			Channel:      c.channel,
+			OpenedAt:     c.openedAt,
+			LastActivity: c.lastActivity,
+			BytesIn:      c.bytesIn,
+			BytesOut:     c.bytesOut,
+		})
+		c.mu.Unlock()
+	}
+	return out
+}
+
+// broadcast 把一段文字訊息送給某個 channel 底下所有 client；channel == "" 代表送給所有人。
+func (h *Hub) broadcast(channel string, message []byte) int {
+	h.mu.Lock()
+	targets := make([]*ClientConn, 0, len(h.sessions))
+	for _, c := range h.sessions {
+		if channel == "" || c.channel == channel {
+			targets = append(targets, c)
+		}
+	}
+	h.mu.Unlock()
+
+	sent := 0
+	for _, c := range targets {
+		if err := c.WriteText(message); err != nil {
+			log.Printf("broadcast to %s failed: %v\n", c.id, err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// reapLoop 定期關閉太久沒有活動的 session，避免殭屍連線無限累積。
+func (h *Hub) reapLoop() {
+	ticker := time.NewTicker(reaperInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.mu.Lock()
+		var idle []*ClientConn
+		for _, c := range h.sessions {
+			if c.isIdle(sessionIdleTimeout) {
+				idle = append(idle, c)
+			}
+		}
+		h.mu.Unlock()
+
+		for _, c := range idle {
+			log.Printf("⏱ reaping idle session %s (no activity for %s)\n", c.id, sessionIdleTimeout)
+			c.Close()
+			h.unregister(c.id)
+		}
+	}
+}
+
+// ---- admin HTTP API ----
+
+func adminAuthorized(r *http.Request) bool {
+	want := os.Getenv("ADMIN_TOKEN")
+	if want == "" {
+		return false // 沒設 token 就直接拒絕，避免 admin API 不小心被公開
+	}
+	// 這把 token 現在也擋 replay（可能洩漏錄音/逐字稿），用常數時間比較，
+	// 不要讓 timing 洩漏前面幾個 byte 有沒有對上。
+	got := r.Header.Get("X-Admin-Token")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+func registerAdminRoutes(mux *http.ServeMux, hub *Hub) {
+	mux.HandleFunc("/admin/sessions", func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(hub.list())
+	})
+
+	mux.HandleFunc("/admin/sessions/", func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// 路徑形狀固定是 /admin/sessions/{id}/cancel
+		id := r.URL.Path[len("/admin/sessions/"):]
+		const suffix = "/cancel"
+		if len(id) <= len(suffix) || id[len(id)-len(suffix):] != suffix {
+			http.NotFound(w, r)
+			return
+		}
+		id = id[:len(id)-len(suffix)]
+
+		c, ok := hub.get(id)
+		if !ok {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+
+		log.Printf("🛑 admin force-closing session %s\n", id)
+		c.Close()
+		hub.unregister(id)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("/admin/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body struct {
+			Channel string `json:"channel"`
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, fmt.Sprintf("bad request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		sent := hub.broadcast(body.Channel, []byte(body.Message))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]int{"sent": sent})
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminAuthorized_RejectsWhenNoTokenConfigured(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "")
+	r := httptest.NewRequest(http.MethodGet, "/admin/sessions", nil)
+	r.Header.Set("X-Admin-Token", "anything")
+
+	if adminAuthorized(r) {
+		t.Fatal("want unauthorized when ADMIN_TOKEN is unset, even if the header is set")
+	}
+}
+
+func TestAdminAuthorized_AcceptsMatchingToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	r := httptest.NewRequest(http.MethodGet, "/admin/sessions", nil)
+	r.Header.Set("X-Admin-Token", "secret")
+
+	if !adminAuthorized(r) {
+		t.Fatal("want authorized when the header matches ADMIN_TOKEN")
+	}
+}
+
+func TestAdminAuthorized_RejectsMismatchedToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	r := httptest.NewRequest(http.MethodGet, "/admin/sessions", nil)
+	r.Header.Set("X-Admin-Token", "wrong")
+
+	if adminAuthorized(r) {
+		t.Fatal("want unauthorized when the header doesn't match ADMIN_TOKEN")
+	}
+}
+
+func TestAdminSessionsCancel_RejectsWithoutToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	mux := http.NewServeMux()
+	registerAdminRoutes(mux, newHub())
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/admin/sessions/some-id/cancel", nil)
+	mux.ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("want 401, got %d", rec.Code)
+	}
+}
+
+func TestAdminSessionsCancel_RejectsWrongMethod(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	mux := http.NewServeMux()
+	registerAdminRoutes(mux, newHub())
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/admin/sessions/some-id/cancel", nil)
+	r.Header.Set("X-Admin-Token", "secret")
+	mux.ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("want 405, got %d", rec.Code)
+	}
+}
+
+func TestAdminSessionsCancel_MissingSuffixIsNotFound(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	mux := http.NewServeMux()
+	registerAdminRoutes(mux, newHub())
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/admin/sessions/some-id", nil)
+	r.Header.Set("X-Admin-Token", "secret")
+	mux.ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("want 404 when the path doesn't end in /cancel, got %d", rec.Code)
+	}
+}
+
+func TestAdminSessionsCancel_IDEqualToSuffixIsNotFound(t *testing.T) {
+	// This path leaves id == "cancel", one byte shorter than the "/cancel" suffix;
+	// the handler's len(id) <= len(suffix) guard must treat it as "no id", not
+	// panic on the negative slice that id[:len(id)-len(suffix)] would otherwise produce.
+	t.Setenv("ADMIN_TOKEN", "secret")
+	mux := http.NewServeMux()
+	registerAdminRoutes(mux, newHub())
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/admin/sessions/cancel", nil)
+	r.Header.Set("X-Admin-Token", "secret")
+	mux.ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("want 404, got %d", rec.Code)
+	}
+}
+
+func TestAdminSessionsCancel_UnknownSessionIsNotFound(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	mux := http.NewServeMux()
+	registerAdminRoutes(mux, newHub())
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/admin/sessions/does-not-exist/cancel", nil)
+	r.Header.Set("X-Admin-Token", "secret")
+	mux.ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("want 404 for an unregistered session id, got %d", rec.Code)
+	}
+}
+
+func TestHub_RegisterListUnregister(t *testing.T) {
+	h := newHub()
+	cc := &ClientConn{id: "abc", remoteAddr: "1.2.3.4", channel: "room1"}
+
+	h.register(cc)
+	if got, ok := h.get("abc"); !ok || got != cc {
+		t.Fatalf("want registered session retrievable by id, got %+v ok=%v", got, ok)
+	}
+
+	list := h.list()
+	if len(list) != 1 || list[0].ID != "abc" || list[0].Channel != "room1" {
+		t.Fatalf("want one session in the snapshot, got %+v", list)
+	}
+
+	h.unregister("abc")
+	if _, ok := h.get("abc"); ok {
+		t.Fatal("want session gone after unregister")
+	}
+}
@@ -4,14 +4,17 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"sync"
+	"regexp"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const (
@@ -35,6 +38,25 @@ var upgrader = websocket.Upgrader{
 
 type openAIEvent map[string]any
 
+var sessionSeq int64
+
+// nextSessionID 產生一個單調遞增、帶時間戳的 session id，方便 admin API 排序/追查。
+func nextSessionID() string {
+	n := atomic.AddInt64(&sessionSeq, 1)
+	return fmt.Sprintf("%d-%04d", time.Now().Unix(), n)
+}
+
+// sessionIDPattern 對應 nextSessionID 的輸出格式；任何要把 session id 當路徑片段
+// 使用的地方（例如 replay route）都要先過這關，擋掉 "../" 之類的東西。
+var sessionIDPattern = regexp.MustCompile(`^[0-9]+-[0-9]{4,}$`)
+
+func validSessionID(id string) bool {
+	return sessionIDPattern.MatchString(id)
+}
+
+// hub 是全域唯一的 session registry，handleClientWS 和 /admin/* 都透過它操作連線。
+var hub = newHub()
+
 func main() {
 	_ = godotenv.Load() // 沒有 .env 也沒關係
 
@@ -43,140 +65,156 @@ func main() {
 	}
 
 	http.HandleFunc("/ws", handleClientWS)
+	registerAdminRoutes(http.DefaultServeMux, hub)
+	registerReplayRoute(http.DefaultServeMux)
+	http.Handle("/metrics", promhttp.Handler())
 	log.Println("listening on :8080/ws")
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
 
 func handleClientWS(w http.ResponseWriter, r *http.Request) {
-	clientConn, err := upgrader.Upgrade(w, r, nil)
+	wsConn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("upgrade error:", err)
 		return
 	}
-	defer clientConn.Close()
+	// 只是在 cc 建立前的錯誤路徑（provider/codec/dial 失敗）兜底；一旦 cc 建立，
+	// 收尾改走下面的 cc.Close()，它會一併關掉 provider、recorder 和這個 conn。
+	defer wsConn.Close()
 	log.Println("client connected")
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// ---- client keepalive (重要：要跟其他 Write 共用同一把鎖，避免 concurrent write) ----
-	var clientWriteMu sync.Mutex
-
-	clientConn.SetReadLimit(8 * 1024 * 1024)
-	clientConn.SetReadDeadline(time.Time{}) // 沒有讀取超時（ping/pong 會維持連線）
-	clientConn.SetPongHandler(func(string) error {
-		clientConn.SetReadDeadline(time.Time{}) // 重置為無超時
+	wsConn.SetReadLimit(8 * 1024 * 1024)
+	wsConn.SetReadDeadline(time.Time{}) // 沒有讀取超時（ping/pong 會維持連線）
+	wsConn.SetPongHandler(func(string) error {
+		wsConn.SetReadDeadline(time.Time{}) // 重置為無超時
 		return nil
 	})
-	go pingLoop(ctx, clientConn, &clientWriteMu)
 
-	// ---- connect to OpenAI Realtime ----
-	openaiConn, err := dialOpenAIRealtime()
+	// ---- 選 provider：讓 operator 用 ?provider=openai / ?provider=echo / ?provider=gemini A/B 測試 backend ----
+	provider, err := newProvider(r.URL.Query().Get("provider"))
 	if err != nil {
-		log.Println("dial openai error:", err)
+		log.Println("provider error:", err)
 		return
 	}
-	defer openaiConn.Close()
 
-	// OpenAI read deadline / pong
-	openaiConn.SetReadLimit(8 * 1024 * 1024)
-	_ = openaiConn.SetReadDeadline(time.Now().Add(pongWait))
-	openaiConn.SetPongHandler(func(string) error {
-		_ = openaiConn.SetReadDeadline(time.Now().Add(pongWait))
-		return nil
-	})
+	// ---- 選 codec：?codec=opus 把頻寬砍到約 1/10，預設（或 codec=pcm）維持原本的 raw PCM16。
+	// ?container= 只有 codec=opus 時有意義，參見 codec.go 裡 Container* 常數的說明。----
+	codec, err := newAudioCodec(r.URL.Query().Get("codec"), r.URL.Query().Get("container"))
+	if err != nil {
+		log.Println("codec error:", err)
+		return
+	}
+	var pacer *audioPacer
+	if codec.Name() != CodecPCM {
+		pacer = newAudioPacer(opusFrameMs * time.Millisecond)
+	}
 
-	// ✅ 單一 writer：所有送給 OpenAI 的訊息（含 ping）都走這個 writer
-	openaiWriter := NewWSWriter(ctx, openaiConn)
-
-	// ---- session.update：開 server VAD + create_response=true（你就不用自己 commit/response.create）----
-	openaiWriter.SendControl(openAIEvent{
-		"type": "session.update",
-		"session": openAIEvent{
-			"type":         "realtime",
-			"instructions": "請用中文與使用者自然對話，回覆以語音為主。",
-			"output_modalities": []string{
-				"audio",
-			},
-			"audio": openAIEvent{
-				"input": openAIEvent{
-					"format": openAIEvent{"type": "audio/pcm", "rate": rateHz},
-					"turn_detection": openAIEvent{
-						"type":                "server_vad",
-						"threshold":           0.5,
-						"prefix_padding_ms":   300,
-						"silence_duration_ms": 600,
-						"create_response":     true, // ✅ 關鍵：自動產生回覆
-					},
-				},
-				"output": openAIEvent{
-					"format": openAIEvent{"type": "audio/pcm", "rate": rateHz},
-					"voice":  "marin",
-					"speed":  1,
-				},
-			},
-		},
-	})
-	log.Println("→ session.update sent (server VAD enabled)")
+	// cc 要等 provider 連上才會建立，但 backpressure callback 要在 Dial（會建立 WSWriter）前
+	// 就註冊好；閉包抓的是這個變數本身，cc 真的被賦值後 callback 才有東西可以寫。
+	var cc *ClientConn
+	if bp, ok := provider.(backpressureNotifier); ok {
+		bp.OnBackpressure(func(sustained bool) {
+			if cc == nil {
+				return
+			}
+			b, _ := json.Marshal(map[string]any{
+				"type":   "x-realtime-backpressure",
+				"active": sustained,
+			})
+			_ = cc.WriteText(b)
+		})
+	}
+
+	if err := provider.Dial(ctx); err != nil {
+		log.Println("dial provider error:", err)
+		return
+	}
+
+	// ---- 註冊進 Hub，讓 /admin/* 能看到這個 session、強制關閉它，或對它廣播 ----
+	// cc.Close() 會一併關掉 provider、recorder 跟底層 conn，所以從這裡開始
+	// 收尾一律走 cc.Close()，不要再各自 defer wsConn.Close()/provider.Close()。
+	cc = newClientConn(nextSessionID(), wsConn, provider, r.URL.Query().Get("channel"))
+	if recordingEnabled() {
+		if rec, err := NewRecorder(cc.id); err != nil {
+			log.Println("recorder init error:", err)
+		} else {
+			cc.recorder = rec
+		}
+	}
+	hub.register(cc)
+	defer func() {
+		cc.Close()
+		hub.unregister(cc.id)
+	}()
 
-	// ---- OpenAI receiver：收到 audio delta 就轉回 binary 給 client ----
+	go pingLoop(ctx, cc)
+
+	// ---- 對話輪次狀態機：處理 barge-in，並把狀態變化正規化成 control event 推給 client ----
+	turn := newTurnStateMachine()
+
+	// ---- provider receiver：收到正規化事件就轉給 client ----
 	go func() {
 		for {
-			_, msg, err := openaiConn.ReadMessage()
+			evt, err := provider.Next()
 			if err != nil {
 				// 這通常是你 cancel / conn close 造成的，屬於正常收尾
-				log.Println("openai read error:", err)
+				log.Println("provider read error:", err)
 				cancel()
 				return
 			}
 
-			var evt openAIEvent
-			if err := json.Unmarshal(msg, &evt); err != nil {
-				log.Println("openai json error:", err)
-				continue
+			bargeIn, control := turn.HandleEvent(evt.Type)
+			if bargeIn {
+				log.Println("🔁 barge-in: user speech interrupted assistant response")
+				_ = provider.Cancel()
+				if clearer, ok := provider.(audioBufferClearer); ok {
+					_ = clearer.ClearOutputBuffer()
+				}
+				_ = cc.WriteText(marshalControlEvent(&TurnControlEvent{Type: "playback.flush"}))
+			}
+			if control != nil {
+				_ = cc.WriteText(marshalControlEvent(control))
 			}
 
-			t, _ := evt["type"].(string)
-
-			switch t {
+			switch evt.Type {
 			case "error":
-				pretty, _ := json.MarshalIndent(evt, "", "  ")
-				log.Printf("❌ openai error event:\n%s\n", string(pretty))
-
-				// 把 error 也丟回 client（文字）
-				clientWriteMu.Lock()
-				_ = clientConn.WriteMessage(websocket.TextMessage, pretty)
-				clientWriteMu.Unlock()
+				log.Printf("❌ provider error event:\n%s\n", evt.Text)
+				cc.recorder.RecordText("out", evt.Text)
+				_ = cc.WriteText([]byte(evt.Text))
 
-			case "response.output_audio.delta":
-				delta, _ := evt["delta"].(string)
-				pcm, err := base64.StdEncoding.DecodeString(delta)
+			case "audio.delta":
+				cc.recorder.RecordOutputPCM(evt.PCM)
+				frames, err := codec.EncodeFromPCM(evt.PCM)
 				if err != nil {
-					log.Println("decode delta error:", err)
+					log.Println("encode error:", err)
 					continue
 				}
-
-				log.Printf("→ sending %d bytes of PCM to client\n", len(pcm))
-				clientWriteMu.Lock()
-				err = clientConn.WriteMessage(websocket.BinaryMessage, pcm)
-				clientWriteMu.Unlock()
-				if err != nil {
-					log.Printf("failed to send PCM to client: %v\n", err)
+				for _, frame := range frames {
+					if pacer != nil {
+						pacer.wait()
+					}
+					log.Printf("→ sending %d bytes (%s) to client\n", len(frame), codec.Name())
+					if err := cc.WriteBinary(frame); err != nil {
+						log.Printf("failed to send audio to client: %v\n", err)
+					}
 				}
 
+			case "transcript.delta":
+				// 逐字稿先不強制轉發給 client，留給錄製功能拼成 transcript.txt。
+				cc.recorder.AppendTranscript(evt.Text)
+
 			case "response.done":
 				log.Println("🟢 response.done")
-
-			default:
-				// 初期你想觀察事件就留著；穩定後可註解掉避免洗版
-				log.Println("openai event:", t)
 			}
 		}
 	}()
 
-	// ---- Client → OpenAI：binary audio 直接 append（不再做 idle commit）----
+	// ---- Client → provider：binary audio 直接 append（不再做 idle commit）----
 	for {
-		msgType, data, err := clientConn.ReadMessage()
+		msgType, data, err := wsConn.ReadMessage()
 		if err != nil {
 			if websocket.IsCloseError(err,
 				websocket.CloseNormalClosure,
@@ -190,15 +228,19 @@ func handleClientWS(w http.ResponseWriter, r *http.Request) {
 			cancel()
 			return
 		}
+		cc.touch(int64(len(data)), 0)
 
 		switch msgType {
 		case websocket.BinaryMessage:
-			// 直接 append。OpenAI Realtime 會自動進行 cut-through
+			// 解碼成 PCM 後直接 append。OpenAI Realtime 會自動進行 cut-through
 			// （當有新 input 時自動中斷 response，不需要手動 cancel）
-			openaiWriter.SendAudio(openAIEvent{
-				"type":  "input_audio_buffer.append",
-				"audio": base64.StdEncoding.EncodeToString(data),
-			})
+			pcm, err := codec.DecodeToPCM(data)
+			if err != nil {
+				log.Println("decode error:", err)
+				continue
+			}
+			cc.recorder.RecordInputPCM(pcm)
+			_ = provider.SendAudioAppend(pcm)
 
 		case websocket.TextMessage:
 			// debug/控制命令（可選）
@@ -206,18 +248,15 @@ func handleClientWS(w http.ResponseWriter, r *http.Request) {
 			switch cmd {
 			case "clear":
 				log.Println("→ cmd clear")
-				openaiWriter.SendControl(openAIEvent{"type": "input_audio_buffer.clear"})
+				_ = provider.Cancel()
 
 			case "cancel":
 				log.Println("→ cmd response.cancel")
-				openaiWriter.SendControl(openAIEvent{"type": "response.cancel"})
+				_ = provider.Cancel()
 			case "force":
 				// 可選：強制讓模型開始回（有時你想立即回不想等 VAD）
 				log.Println("→ cmd response.create (force)")
-				openaiWriter.SendControl(openAIEvent{
-					"type":     "response.create",
-					"response": openAIEvent{"output_modalities": []string{"audio"}},
-				})
+				_ = provider.Commit()
 
 			default:
 				log.Println("client text:", cmd)
@@ -226,28 +265,26 @@ func handleClientWS(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func dialOpenAIRealtime() (*websocket.Conn, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	h := http.Header{}
-	h.Set("Authorization", "Bearer "+apiKey)
-
-	conn, _, err := websocket.DefaultDialer.Dial(openAIRealtimeURL, h)
-	return conn, err
-}
-
 // ---- 單一 Writer（含 ping）----
 // gorilla/websocket：同一條連線只允許一個 goroutine 寫入，這個結構就是為了解決它
 type WSWriter struct {
-	conn      *websocket.Conn
-	controlCh chan []byte
-	audioCh   chan []byte
+	conn       *websocket.Conn
+	controlCh  chan []byte
+	audioQueue *AudioQueue
 }
 
-func NewWSWriter(ctx context.Context, conn *websocket.Conn) *WSWriter {
+// NewWSWriter 建立 writer；onBackpressure 在音訊佇列持續壅塞/解除時被呼叫一次，
+// nil 代表呼叫端不關心（例如還沒接上 Hub/ClientConn 前）。
+func NewWSWriter(ctx context.Context, conn *websocket.Conn, onBackpressure func(sustained bool)) *WSWriter {
 	w := &WSWriter{
 		conn:      conn,
-		controlCh: make(chan []byte),    // 不丟，保序
-		audioCh:   make(chan []byte, 4), // ~80ms audio buffer
+		controlCh: make(chan []byte), // 不丟，保序
+		audioQueue: newAudioQueue(
+			audioQueueCapacityFromEnv(),
+			overflowStrategyFromEnv(),
+			blockTimeoutFromEnv(),
+			onBackpressure,
+		),
 	}
 
 	go w.loop(ctx)
@@ -270,10 +307,14 @@ func (w *WSWriter) loop(ctx context.Context) {
 				return
 			}
 
-		// 2️⃣ Audio（可能被丟）
-		case msg := <-w.audioCh:
+		// 2️⃣ Audio（可能因為 overflow 被丟、合併或延後）
+		case pcm := <-w.audioQueue.items:
+			b, _ := json.Marshal(openAIEvent{
+				"type":  "input_audio_buffer.append",
+				"audio": base64.StdEncoding.EncodeToString(pcm),
+			})
 			_ = w.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := w.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			if err := w.conn.WriteMessage(websocket.TextMessage, b); err != nil {
 				return
 			}
 
@@ -292,21 +333,14 @@ func (w *WSWriter) SendControl(v any) {
 	w.controlCh <- b // block 是刻意的
 }
 
-func (w *WSWriter) SendAudio(v any) {
-	b, _ := json.Marshal(v)
-
-	select {
-	case w.audioCh <- b:
-		// 成功送進 buffer
-	default:
-		// buffer 滿了，丟掉最舊的
-		<-w.audioCh
-		w.audioCh <- b
-	}
+// SendAudio 把一段 PCM16 放進 backpressure-aware 的佇列；滿了之後怎麼處理
+// 由 AUDIO_OVERFLOW_STRATEGY 決定（預設維持舊行為：丟最舊的）。
+func (w *WSWriter) SendAudio(pcm []byte) {
+	w.audioQueue.Push(pcm)
 }
 
-// clientConn 的 ping loop：注意要用同一把 clientWriteMu
-func pingLoop(ctx context.Context, conn *websocket.Conn, mu *sync.Mutex) {
+// client 的 ping loop：透過 ClientConn.writeMu 跟其他 Write 共用同一把鎖，避免 concurrent write
+func pingLoop(ctx context.Context, cc *ClientConn) {
 	t := time.NewTicker(pingPeriod)
 	defer t.Stop()
 	for {
@@ -314,10 +348,10 @@ func pingLoop(ctx context.Context, conn *websocket.Conn, mu *sync.Mutex) {
 		case <-ctx.Done():
 			return
 		case <-t.C:
-			_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
-			mu.Lock()
-			err := conn.WriteMessage(websocket.PingMessage, nil)
-			mu.Unlock()
+			cc.writeMu.Lock()
+			_ = cc.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			err := cc.conn.WriteMessage(websocket.PingMessage, nil)
+			cc.writeMu.Unlock()
 			if err != nil {
 				return
 			}
@@ -0,0 +1,63 @@
+package main
+
+import "errors"
+
+var errNotOggPage = errors.New("not an Ogg page (missing \"OggS\" capture pattern)")
+
+// oggPacketExtractor 把一個 Ogg page 拆成裡面包的 raw packet，只關心 Opus-in-Ogg
+// （Firefox 的 MediaRecorder 用 audio/ogg;codecs=opus 錄音時就是這個格式）。
+// 不處理跨 page 延續的 packet（header 的 continued-packet flag）——對 20ms 的語音
+// frame 來說，一個 page 幾乎都剛好裝得下一個完整 packet，這個限制在 MediaRecorder
+// 的輸出下不會觸發。
+type oggPacketExtractor struct {
+	sawHead    bool
+	sawComment bool
+}
+
+// extract 回傳 page 裡面的音訊 packet，已經濾掉 OpusHead / OpusTags 這兩個 metadata packet。
+func (e *oggPacketExtractor) extract(page []byte) ([][]byte, error) {
+	const pageHeaderLen = 27
+	if len(page) < pageHeaderLen || string(page[0:4]) != "OggS" {
+		return nil, errNotOggPage
+	}
+
+	segCount := int(page[26])
+	if len(page) < pageHeaderLen+segCount {
+		return nil, errors.New("truncated ogg page: segment table overruns page")
+	}
+	segTable := page[pageHeaderLen : pageHeaderLen+segCount]
+	data := page[pageHeaderLen+segCount:]
+
+	var packets [][]byte
+	offset, packetLen := 0, 0
+	for _, segLen := range segTable {
+		packetLen += int(segLen)
+		if segLen < 255 {
+			// lacing 值 < 255 代表這個 packet 到此結束。
+			if offset+packetLen > len(data) {
+				return nil, errors.New("truncated ogg page: packet overruns payload")
+			}
+			packets = append(packets, data[offset:offset+packetLen])
+			offset += packetLen
+			packetLen = 0
+		}
+	}
+	// 255 結尾代表 packet 會延續到下個 page；目前不支援，剩下的位元組直接丟棄。
+
+	return e.filterMetadataPackets(packets), nil
+}
+
+func (e *oggPacketExtractor) filterMetadataPackets(packets [][]byte) [][]byte {
+	var audio [][]byte
+	for _, p := range packets {
+		switch {
+		case !e.sawHead && len(p) >= 8 && string(p[:8]) == "OpusHead":
+			e.sawHead = true
+		case !e.sawComment && len(p) >= 8 && string(p[:8]) == "OpusTags":
+			e.sawComment = true
+		default:
+			audio = append(audio, p)
+		}
+	}
+	return audio
+}
@@ -0,0 +1,136 @@
+package main
+
+import "testing"
+
+// buildOggPage 組一個最小可用的 Ogg page：27 byte header（capture pattern 在
+// [0:4]，segment count 在 [26]）後面接 segment table 跟 payload，方便測試
+// 不用手刻真正合法的 checksum/granule position。
+func buildOggPage(segTable []byte, payload []byte) []byte {
+	page := make([]byte, 27)
+	copy(page[0:4], "OggS")
+	page[26] = byte(len(segTable))
+	page = append(page, segTable...)
+	page = append(page, payload...)
+	return page
+}
+
+func TestOggPacketExtractor_RejectsMissingCapturePattern(t *testing.T) {
+	e := &oggPacketExtractor{}
+	_, err := e.extract([]byte("not an ogg page at all"))
+	if err != errNotOggPage {
+		t.Fatalf("want errNotOggPage, got %v", err)
+	}
+}
+
+func TestOggPacketExtractor_TruncatedHeaderIsRejected(t *testing.T) {
+	e := &oggPacketExtractor{}
+	_, err := e.extract([]byte("OggS"))
+	if err != errNotOggPage {
+		t.Fatalf("want errNotOggPage for a page shorter than the header, got %v", err)
+	}
+}
+
+func TestOggPacketExtractor_SegCountOverrunsPageIsRejected(t *testing.T) {
+	e := &oggPacketExtractor{}
+	page := make([]byte, 27)
+	copy(page[0:4], "OggS")
+	page[26] = 5 // claims 5 segments but there are zero bytes left for the table
+
+	_, err := e.extract(page)
+	if err == nil {
+		t.Fatal("want an error when segCount overruns the page")
+	}
+}
+
+func TestOggPacketExtractor_PacketOverrunsPayloadIsRejected(t *testing.T) {
+	e := &oggPacketExtractor{}
+	// lacing value says the packet is 10 bytes, but the payload only has 2.
+	page := buildOggPage([]byte{10}, []byte{0x01, 0x02})
+
+	_, err := e.extract(page)
+	if err == nil {
+		t.Fatal("want an error when the packet length overruns the payload")
+	}
+}
+
+func TestOggPacketExtractor_SingleSegmentPacket(t *testing.T) {
+	e := &oggPacketExtractor{}
+	payload := []byte{0xAA, 0xBB, 0xCC}
+	page := buildOggPage([]byte{3}, payload)
+
+	packets, err := e.extract(page)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(packets) != 1 || string(packets[0]) != string(payload) {
+		t.Fatalf("want [%v], got %v", payload, packets)
+	}
+}
+
+func TestOggPacketExtractor_LacingValue255AtPageBoundaryIsDropped(t *testing.T) {
+	e := &oggPacketExtractor{}
+	// A lacing value of exactly 255 means "packet continues on the next page".
+	// We don't support cross-page continuation, so the trailing bytes should
+	// simply be dropped rather than returned as a (truncated) packet.
+	payload := make([]byte, 255)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	page := buildOggPage([]byte{255}, payload)
+
+	packets, err := e.extract(page)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(packets) != 0 {
+		t.Fatalf("want no completed packets for a page ending in a 255 lacing value, got %v", packets)
+	}
+}
+
+func TestOggPacketExtractor_MultipleSegmentsFormOnePacket(t *testing.T) {
+	e := &oggPacketExtractor{}
+	// 255 + 10 means one packet spanning two segments (265 bytes), all within this page.
+	payload := make([]byte, 265)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	page := buildOggPage([]byte{255, 10}, payload)
+
+	packets, err := e.extract(page)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(packets) != 1 || len(packets[0]) != 265 {
+		t.Fatalf("want a single 265-byte packet, got %d packets", len(packets))
+	}
+}
+
+func TestOggPacketExtractor_FiltersOpusHeadAndOpusTags(t *testing.T) {
+	e := &oggPacketExtractor{}
+
+	head := append([]byte("OpusHead"), 0x01, 0x02)
+	page := buildOggPage([]byte{byte(len(head))}, head)
+	packets, err := e.extract(page)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(packets) != 0 {
+		t.Fatalf("want OpusHead filtered out, got %v", packets)
+	}
+	if !e.sawHead {
+		t.Fatal("want sawHead to be set after an OpusHead packet")
+	}
+
+	tags := append([]byte("OpusTags"), 0x03)
+	page = buildOggPage([]byte{byte(len(tags))}, tags)
+	packets, err = e.extract(page)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(packets) != 0 {
+		t.Fatalf("want OpusTags filtered out, got %v", packets)
+	}
+	if !e.sawComment {
+		t.Fatal("want sawComment to be set after an OpusTags packet")
+	}
+}
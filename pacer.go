@@ -0,0 +1,33 @@
+package main
+
+import "time"
+
+// audioPacer 讓輸出音訊 frame 照固定節奏送出，而不是 provider 一次推一大包就整包轉送，
+// 這樣瀏覽器端的 AudioContext 才能平滑播放。落後超過一個 frame 時直接從現在重新起算，
+// 避免「硬補」造成延遲越補越大。
+type audioPacer struct {
+	frameInterval time.Duration
+	nextDeadline  time.Time
+}
+
+func newAudioPacer(frameInterval time.Duration) *audioPacer {
+	return &audioPacer{frameInterval: frameInterval}
+}
+
+func (p *audioPacer) wait() {
+	now := time.Now()
+	if p.nextDeadline.IsZero() {
+		p.nextDeadline = now.Add(p.frameInterval)
+		return
+	}
+
+	if d := time.Until(p.nextDeadline); d > 0 {
+		time.Sleep(d)
+		now = time.Now()
+	}
+
+	if now.After(p.nextDeadline.Add(p.frameInterval)) {
+		p.nextDeadline = now
+	}
+	p.nextDeadline = p.nextDeadline.Add(p.frameInterval)
+}
@@ -0,0 +1,540 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// NormalizedEvent 是跨 provider 統一的事件格式，client 端只認這個 schema，
+// 不需要知道背後接的是哪家的 Realtime API。
+type NormalizedEvent struct {
+	Type string // session.start / audio.delta / transcript.delta / response.done / error
+	PCM  []byte // 當 Type == audio.delta 時帶的 PCM bytes
+	Text string // 當 Type == transcript.delta / error 時帶的文字
+	Raw  openAIEvent
+}
+
+// RealtimeProvider 是所有語音 backend 要實作的介面，讓 handleClientWS
+// 不用管背後接的是 OpenAI 還是其他家的 Realtime pipeline。
+type RealtimeProvider interface {
+	// Dial 建立與背後服務的連線並完成初始化（例如送 session.update）。
+	Dial(ctx context.Context) error
+	// SendAudioAppend 把一段 PCM 送進輸入緩衝區。
+	SendAudioAppend(pcm []byte) error
+	// Commit 手動提交目前的輸入緩衝區（沒有 server VAD 時才需要）。
+	Commit() error
+	// Cancel 中斷目前正在產生的回覆。
+	Cancel() error
+	// Next 阻塞直到收到下一個事件，回傳值已經是正規化過的格式。
+	Next() (NormalizedEvent, error)
+	// TranslateEvent 把 provider 的原始訊息轉成 NormalizedEvent，ok=false 代表可以略過（例如心跳）。
+	TranslateEvent(raw []byte) (evt NormalizedEvent, ok bool)
+	Close() error
+}
+
+// 可用的 provider 名稱，對應 /ws?provider= 這個 query string。
+const (
+	ProviderOpenAI = "openai"
+	ProviderGemini = "gemini" // 真的第二個 backend，讓 operator 能 A/B 測試，不是本地 stub
+	ProviderEcho   = "echo"   // 佔位用的本地 pipeline，不連任何外部 API（main 啟動時仍會檢查 OPENAI_API_KEY）
+)
+
+// newProvider 依照 name 建立對應的 RealtimeProvider，預設是 OpenAI。
+func newProvider(name string) (RealtimeProvider, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", ProviderOpenAI:
+		return newOpenAIProvider(), nil
+	case ProviderGemini:
+		return newGeminiProvider(), nil
+	case ProviderEcho:
+		return newEchoProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown realtime provider: %q", name)
+	}
+}
+
+// ---- OpenAI adapter ----
+
+// openAIKeyState 追蹤一把 key 最近的使用狀況，用來做 round-robin + 簡單的錯誤熔斷。
+type openAIKeyState struct {
+	key        string
+	errorCount int64
+	lastUsed   time.Time
+}
+
+var (
+	openAIKeysMu sync.Mutex
+	openAIKeys   []*openAIKeyState
+	openAIKeyIdx uint64
+)
+
+// loadOpenAIKeys 從 OPENAI_API_KEYS（逗號分隔，支援多把 key 輪詢）
+// 或舊的單把 OPENAI_API_KEY 載入並快取。
+func loadOpenAIKeys() []*openAIKeyState {
+	openAIKeysMu.Lock()
+	defer openAIKeysMu.Unlock()
+
+	if openAIKeys != nil {
+		return openAIKeys
+	}
+
+	raw := os.Getenv("OPENAI_API_KEYS")
+	if raw == "" {
+		raw = os.Getenv("OPENAI_API_KEY")
+	}
+	for _, k := range strings.Split(raw, ",") {
+		k = strings.TrimSpace(k)
+		if k != "" {
+			openAIKeys = append(openAIKeys, &openAIKeyState{key: k})
+		}
+	}
+	return openAIKeys
+}
+
+// nextOpenAIKey 用 round-robin 選一把 key；如果同一把 key 最近錯太多次，跳過它。
+func nextOpenAIKey() (*openAIKeyState, error) {
+	keys := loadOpenAIKeys()
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("missing env OPENAI_API_KEY")
+	}
+
+	for i := 0; i < len(keys); i++ {
+		idx := int(atomic.AddUint64(&openAIKeyIdx, 1)) % len(keys)
+		ks := keys[idx]
+
+		openAIKeysMu.Lock()
+		tooManyErrors := ks.errorCount >= 5 && time.Since(ks.lastUsed) < time.Minute
+		if !tooManyErrors {
+			ks.lastUsed = time.Now()
+		}
+		openAIKeysMu.Unlock()
+
+		if !tooManyErrors {
+			return ks, nil
+		}
+	}
+
+	// 全部都在冷卻中，還是挑一把用，總比整個服務掛掉好。
+	return keys[int(atomic.AddUint64(&openAIKeyIdx, 1))%len(keys)], nil
+}
+
+func markOpenAIKeyError(ks *openAIKeyState) {
+	if ks == nil {
+		return
+	}
+	openAIKeysMu.Lock()
+	ks.errorCount++
+	openAIKeysMu.Unlock()
+}
+
+type openAIProvider struct {
+	conn   *websocket.Conn
+	writer *WSWriter
+	key    *openAIKeyState
+
+	onBackpressure func(sustained bool)
+}
+
+// OnBackpressure 讓上層（main.go）在 SendAudio 佇列持續壅塞/解除時收到通知，
+// 好推一個 x-realtime-backpressure control event 給 client。
+func (p *openAIProvider) OnBackpressure(cb func(sustained bool)) {
+	p.onBackpressure = cb
+}
+
+func newOpenAIProvider() *openAIProvider {
+	return &openAIProvider{}
+}
+
+func (p *openAIProvider) Dial(ctx context.Context) error {
+	ks, err := nextOpenAIKey()
+	if err != nil {
+		return err
+	}
+	p.key = ks
+
+	h := http.Header{}
+	h.Set("Authorization", "Bearer "+ks.key)
+
+	conn, _, err := websocket.DefaultDialer.Dial(openAIRealtimeURL, h)
+	if err != nil {
+		markOpenAIKeyError(ks)
+		return err
+	}
+
+	conn.SetReadLimit(8 * 1024 * 1024)
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	p.conn = conn
+	p.writer = NewWSWriter(ctx, conn, p.onBackpressure)
+
+	// ---- session.update：開 server VAD + create_response=true ----
+	p.writer.SendControl(openAIEvent{
+		"type": "session.update",
+		"session": openAIEvent{
+			"type":         "realtime",
+			"instructions": "請用中文與使用者自然對話，回覆以語音為主。",
+			"output_modalities": []string{
+				"audio",
+			},
+			"audio": openAIEvent{
+				"input": openAIEvent{
+					"format": openAIEvent{"type": "audio/pcm", "rate": rateHz},
+					"turn_detection": openAIEvent{
+						"type":                "server_vad",
+						"threshold":           0.5,
+						"prefix_padding_ms":   300,
+						"silence_duration_ms": 600,
+						"create_response":     true,
+					},
+				},
+				"output": openAIEvent{
+					"format": openAIEvent{"type": "audio/pcm", "rate": rateHz},
+					"voice":  "marin",
+					"speed":  1,
+				},
+			},
+		},
+	})
+	log.Println("→ session.update sent (server VAD enabled)")
+	return nil
+}
+
+func (p *openAIProvider) SendAudioAppend(pcm []byte) error {
+	p.writer.SendAudio(pcm) // base64/JSON 包裝留到佇列真的要送出時才做，這樣 coalesce 才有意義
+	return nil
+}
+
+func (p *openAIProvider) Commit() error {
+	p.writer.SendControl(openAIEvent{"type": "input_audio_buffer.commit"})
+	return nil
+}
+
+func (p *openAIProvider) Cancel() error {
+	p.writer.SendControl(openAIEvent{"type": "response.cancel"})
+	return nil
+}
+
+// ClearOutputBuffer 清掉 OpenAI 端還沒播完的 output audio buffer；
+// barge-in 發生時要跟 Cancel 一起送，不然殘留的音訊還是會播給 client。
+func (p *openAIProvider) ClearOutputBuffer() error {
+	p.writer.SendControl(openAIEvent{"type": "output_audio_buffer.clear"})
+	return nil
+}
+
+func (p *openAIProvider) Next() (NormalizedEvent, error) {
+	for {
+		_, msg, err := p.conn.ReadMessage()
+		if err != nil {
+			markOpenAIKeyError(p.key)
+			return NormalizedEvent{}, err
+		}
+		if evt, ok := p.TranslateEvent(msg); ok {
+			return evt, nil
+		}
+	}
+}
+
+func (p *openAIProvider) TranslateEvent(raw []byte) (NormalizedEvent, bool) {
+	var evt openAIEvent
+	if err := json.Unmarshal(raw, &evt); err != nil {
+		log.Println("openai json error:", err)
+		return NormalizedEvent{}, false
+	}
+
+	t, _ := evt["type"].(string)
+	switch t {
+	case "error":
+		markOpenAIKeyError(p.key)
+		pretty, _ := json.MarshalIndent(evt, "", "  ")
+		return NormalizedEvent{Type: "error", Text: string(pretty), Raw: evt}, true
+
+	case "response.output_audio.delta":
+		delta, _ := evt["delta"].(string)
+		pcm, err := base64.StdEncoding.DecodeString(delta)
+		if err != nil {
+			log.Println("decode delta error:", err)
+			return NormalizedEvent{}, false
+		}
+		return NormalizedEvent{Type: "audio.delta", PCM: pcm, Raw: evt}, true
+
+	case "response.output_audio_transcript.delta", "response.output_audio_transcript.done":
+		text, _ := evt["delta"].(string)
+		if text == "" {
+			text, _ = evt["transcript"].(string)
+		}
+		return NormalizedEvent{Type: "transcript.delta", Text: text, Raw: evt}, true
+
+	case "response.done":
+		return NormalizedEvent{Type: "response.done", Raw: evt}, true
+
+	case "input_audio_buffer.speech_started":
+		return NormalizedEvent{Type: "turn.speech_started", Raw: evt}, true
+
+	case "input_audio_buffer.speech_stopped":
+		return NormalizedEvent{Type: "turn.speech_stopped", Raw: evt}, true
+
+	case "response.created":
+		return NormalizedEvent{Type: "turn.response_created", Raw: evt}, true
+
+	default:
+		// 其他事件先不轉發給 client，但留著 log 方便觀察。
+		log.Println("openai event:", t)
+		return NormalizedEvent{}, false
+	}
+}
+
+func (p *openAIProvider) Close() error {
+	if p.conn != nil {
+		return p.conn.Close()
+	}
+	return nil
+}
+
+// ---- Gemini adapter ----
+// 真的第二個 backend（不是本地 echo stub），接 Gemini Live API 的 BidiGenerateContent
+// WS 端點，讓 operator 可以用 ?provider=gemini 跟 OpenAI 做 A/B 測試。
+// 事件 schema 跟 OpenAI 長得不一樣（setup/serverContent/realtimeInput vs session/response），
+// 差異全部封在這個檔案裡，TranslateEvent 吐出去的還是同一份 NormalizedEvent。
+const geminiLiveURLTmpl = "wss://generativelanguage.googleapis.com/ws/google.ai.generativelanguage.v1alpha.GenerativeService.BidiGenerateContent?key=%s"
+
+// geminiModel 可以用環境變數覆蓋，預設挑一個支援 audio-in/audio-out 的 flash 模型。
+func geminiModel() string {
+	if m := os.Getenv("GEMINI_MODEL"); m != "" {
+		return m
+	}
+	return "models/gemini-2.0-flash-exp"
+}
+
+type geminiProvider struct {
+	conn   *websocket.Conn
+	writer *WSWriter
+
+	onBackpressure func(sustained bool)
+
+	// turnActive 追蹤目前這個 response 有沒有已經送過 turn.response_created；
+	// Gemini 的 serverContent 沒有獨立的「response 開始了」事件，所以我們拿
+	// 第一個 modelTurn chunk 來代替，跟 OpenAI 的 response.created 對齊，讓
+	// turnstate.go 的狀態機（barge-in 判斷全靠它）才能追得到 Gemini 的 session。
+	turnActive bool
+	// pending 放 TranslateEvent 這次多翻出來、還沒來得及回傳的事件（目前只有
+	// 「先吐 turn.response_created，把原本那筆 audio.delta 留到下一次 Next()」
+	// 這個情境），讓介面維持「一次呼叫回傳一個事件」。
+	pending []NormalizedEvent
+}
+
+func newGeminiProvider() *geminiProvider {
+	return &geminiProvider{}
+}
+
+// OnBackpressure 同 openAIProvider，讓 main.go 能統一用 backpressureNotifier 斷言處理。
+func (p *geminiProvider) OnBackpressure(cb func(sustained bool)) {
+	p.onBackpressure = cb
+}
+
+func (p *geminiProvider) Dial(ctx context.Context) error {
+	apiKey := os.Getenv("GEMINI_API_KEY")
+	if apiKey == "" {
+		return fmt.Errorf("missing env GEMINI_API_KEY")
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf(geminiLiveURLTmpl, apiKey), nil)
+	if err != nil {
+		return err
+	}
+
+	conn.SetReadLimit(8 * 1024 * 1024)
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	p.conn = conn
+	p.writer = NewWSWriter(ctx, conn, p.onBackpressure)
+
+	// ---- setup：宣告 model + 要 audio modality，Gemini 端會自動做 VAD/turn detection ----
+	p.writer.SendControl(openAIEvent{
+		"setup": openAIEvent{
+			"model": geminiModel(),
+			"generationConfig": openAIEvent{
+				"responseModalities": []string{"AUDIO"},
+			},
+		},
+	})
+	log.Println("→ gemini setup sent")
+	return nil
+}
+
+// SendAudioAppend 把 PCM16 包成 Gemini 的 realtimeInput.mediaChunks 送出去。
+// WSWriter.loop 原本就是用 base64 包裝整段 audioQueue 的內容，這裡沿用同一條路，
+// 只是最後吐出來的 JSON key 跟 OpenAI 不一樣（在 loop 裡用不到，走 writer.audioQueue 即可）。
+func (p *geminiProvider) SendAudioAppend(pcm []byte) error {
+	p.writer.SendAudio(pcm)
+	return nil
+}
+
+// Commit 對應 Gemini 的 activityEnd 訊號；自動 VAD 關掉時才需要手動送。
+func (p *geminiProvider) Commit() error {
+	p.writer.SendControl(openAIEvent{
+		"realtimeInput": openAIEvent{"activityEnd": openAIEvent{}},
+	})
+	return nil
+}
+
+// Cancel：Gemini Live 靠 server 端 VAD 自動偵測 barge-in 並回傳 interrupted，
+// 沒有對應 OpenAI response.cancel 的主動中斷 API，這裡只是個 no-op 佔位。
+func (p *geminiProvider) Cancel() error { return nil }
+
+func (p *geminiProvider) Next() (NormalizedEvent, error) {
+	if len(p.pending) > 0 {
+		evt := p.pending[0]
+		p.pending = p.pending[1:]
+		return evt, nil
+	}
+
+	for {
+		_, msg, err := p.conn.ReadMessage()
+		if err != nil {
+			return NormalizedEvent{}, err
+		}
+		if evt, ok := p.TranslateEvent(msg); ok {
+			return evt, nil
+		}
+	}
+}
+
+func (p *geminiProvider) TranslateEvent(raw []byte) (NormalizedEvent, bool) {
+	var evt openAIEvent
+	if err := json.Unmarshal(raw, &evt); err != nil {
+		log.Println("gemini json error:", err)
+		return NormalizedEvent{}, false
+	}
+
+	sc, _ := evt["serverContent"].(map[string]any)
+	if sc == nil {
+		log.Println("gemini event (no serverContent):", evt)
+		return NormalizedEvent{}, false
+	}
+
+	if interrupted, _ := sc["interrupted"].(bool); interrupted {
+		p.turnActive = false
+		return NormalizedEvent{Type: "turn.speech_started", Raw: evt}, true
+	}
+
+	if modelTurn, _ := sc["modelTurn"].(map[string]any); modelTurn != nil {
+		parts, _ := modelTurn["parts"].([]any)
+		for _, raw := range parts {
+			part, _ := raw.(map[string]any)
+			inline, _ := part["inlineData"].(map[string]any)
+			if inline == nil {
+				continue
+			}
+			data, _ := inline["data"].(string)
+			pcm, err := base64.StdEncoding.DecodeString(data)
+			if err != nil {
+				log.Println("gemini decode inlineData error:", err)
+				continue
+			}
+
+			audioEvt := NormalizedEvent{Type: "audio.delta", PCM: pcm, Raw: evt}
+			if !p.turnActive {
+				// 第一個 modelTurn chunk：先讓 turn state machine 知道 assistant
+				// 開始講話了（對應 OpenAI 的 response.created），audio.delta
+				// 留到下一次 Next() 再吐。沒有這一步，turnstate.go 永遠不會進
+				// TurnAssistantSpeaking，barge-in 判斷對 Gemini session 就是死的。
+				p.turnActive = true
+				p.pending = append(p.pending, audioEvt)
+				return NormalizedEvent{Type: "turn.response_created", Raw: evt}, true
+			}
+			return audioEvt, true
+		}
+	}
+
+	if turnComplete, _ := sc["turnComplete"].(bool); turnComplete {
+		p.turnActive = false
+		return NormalizedEvent{Type: "response.done", Raw: evt}, true
+	}
+
+	log.Println("gemini serverContent event with no recognised field")
+	return NormalizedEvent{}, false
+}
+
+func (p *geminiProvider) Close() error {
+	if p.conn != nil {
+		return p.conn.Close()
+	}
+	return nil
+}
+
+// ---- Echo adapter ----
+// 最簡單的本地 pipeline：Dial 時送一個 session.start，把收到的 PCM 原封不動當 audio.delta 回送，
+// Commit 時送 response.done；不會產生 transcript.delta。
+// 用來在不打 OpenAI API、或想 A/B 測試新 backend 串接方式時，先把前端協定跑通。
+type echoProvider struct {
+	events chan NormalizedEvent
+	closed chan struct{}
+}
+
+func newEchoProvider() *echoProvider {
+	return &echoProvider{
+		events: make(chan NormalizedEvent, 16),
+		closed: make(chan struct{}),
+	}
+}
+
+func (p *echoProvider) Dial(ctx context.Context) error {
+	p.events <- NormalizedEvent{Type: "session.start"}
+	return nil
+}
+
+func (p *echoProvider) SendAudioAppend(pcm []byte) error {
+	select {
+	case p.events <- (NormalizedEvent{Type: "audio.delta", PCM: pcm}):
+	case <-p.closed:
+	}
+	return nil
+}
+
+func (p *echoProvider) Commit() error {
+	select {
+	case p.events <- (NormalizedEvent{Type: "response.done"}):
+	case <-p.closed:
+	}
+	return nil
+}
+
+func (p *echoProvider) Cancel() error { return nil }
+
+func (p *echoProvider) Next() (NormalizedEvent, error) {
+	select {
+	case evt := <-p.events:
+		return evt, nil
+	case <-p.closed:
+		return NormalizedEvent{}, fmt.Errorf("echo provider closed")
+	}
+}
+
+func (p *echoProvider) TranslateEvent(raw []byte) (NormalizedEvent, bool) {
+	// echo provider 不走原始位元組協定，事件都是本地產生的，這裡用不到。
+	return NormalizedEvent{}, false
+}
+
+func (p *echoProvider) Close() error {
+	close(p.closed)
+	return nil
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// resetOpenAIKeyState 清掉 loadOpenAIKeys 的快取和 round-robin 游標，讓每個測試
+// 都是從乾淨狀態開始；production code 只在 process 生命週期內 load 一次。
+func resetOpenAIKeyState() {
+	openAIKeysMu.Lock()
+	openAIKeys = nil
+	openAIKeyIdx = 0
+	openAIKeysMu.Unlock()
+}
+
+func TestLoadOpenAIKeys_SplitsCommaSeparatedList(t *testing.T) {
+	resetOpenAIKeyState()
+	t.Setenv("OPENAI_API_KEYS", "key-a, key-b ,key-c")
+	t.Setenv("OPENAI_API_KEY", "")
+
+	keys := loadOpenAIKeys()
+	if len(keys) != 3 {
+		t.Fatalf("want 3 keys, got %d: %+v", len(keys), keys)
+	}
+	if keys[0].key != "key-a" || keys[1].key != "key-b" || keys[2].key != "key-c" {
+		t.Fatalf("want trimmed keys [key-a key-b key-c], got %+v", keys)
+	}
+}
+
+func TestLoadOpenAIKeys_FallsBackToSingleKeyEnv(t *testing.T) {
+	resetOpenAIKeyState()
+	t.Setenv("OPENAI_API_KEYS", "")
+	t.Setenv("OPENAI_API_KEY", "solo-key")
+
+	keys := loadOpenAIKeys()
+	if len(keys) != 1 || keys[0].key != "solo-key" {
+		t.Fatalf("want [solo-key], got %+v", keys)
+	}
+}
+
+func TestNextOpenAIKey_RoundRobinsAcrossKeys(t *testing.T) {
+	resetOpenAIKeyState()
+	t.Setenv("OPENAI_API_KEYS", "a,b,c")
+	t.Setenv("OPENAI_API_KEY", "")
+
+	seen := make(map[string]int)
+	for i := 0; i < 9; i++ {
+		ks, err := nextOpenAIKey()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		seen[ks.key]++
+	}
+	if len(seen) != 3 || seen["a"] != 3 || seen["b"] != 3 || seen["c"] != 3 {
+		t.Fatalf("want each key picked 3 times over 9 calls, got %v", seen)
+	}
+}
+
+func TestNextOpenAIKey_SkipsKeyWithTooManyRecentErrors(t *testing.T) {
+	resetOpenAIKeyState()
+	t.Setenv("OPENAI_API_KEYS", "good,bad")
+	t.Setenv("OPENAI_API_KEY", "")
+
+	keys := loadOpenAIKeys()
+	var bad *openAIKeyState
+	for _, ks := range keys {
+		if ks.key == "bad" {
+			bad = ks
+		}
+	}
+	bad.errorCount = 5
+	bad.lastUsed = time.Now()
+
+	for i := 0; i < 4; i++ {
+		ks, err := nextOpenAIKey()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ks.key == "bad" {
+			t.Fatalf("want the cooling-down key skipped while it has recent errors, got picked")
+		}
+	}
+}
+
+func TestNextOpenAIKey_NoKeysConfiguredReturnsError(t *testing.T) {
+	resetOpenAIKeyState()
+	t.Setenv("OPENAI_API_KEYS", "")
+	t.Setenv("OPENAI_API_KEY", "")
+
+	if _, err := nextOpenAIKey(); err == nil {
+		t.Fatal("want an error when no OpenAI key is configured")
+	}
+}
+
+func TestMarkOpenAIKeyError_IncrementsCount(t *testing.T) {
+	ks := &openAIKeyState{key: "x"}
+	markOpenAIKeyError(ks)
+	markOpenAIKeyError(ks)
+	if ks.errorCount != 2 {
+		t.Fatalf("want errorCount 2, got %d", ks.errorCount)
+	}
+}
+
+func TestNewProvider_UnknownNameReturnsError(t *testing.T) {
+	if _, err := newProvider("not-a-real-provider"); err == nil {
+		t.Fatal("want an error for an unrecognised provider name")
+	}
+}
+
+func TestNewProvider_DefaultsToOpenAI(t *testing.T) {
+	p, err := newProvider("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := p.(*openAIProvider); !ok {
+		t.Fatalf("want the default provider to be openAIProvider, got %T", p)
+	}
+}
+
+// ---- openAIProvider.TranslateEvent ----
+
+func TestOpenAIProvider_TranslateEvent(t *testing.T) {
+	pcm := []byte{0x01, 0x02, 0x03, 0x04}
+	encodedPCM := base64.StdEncoding.EncodeToString(pcm)
+
+	cases := []struct {
+		name     string
+		raw      string
+		wantOK   bool
+		wantType string
+		wantPCM  []byte
+		wantText string
+	}{
+		{
+			name:     "error event",
+			raw:      `{"type":"error","message":"boom"}`,
+			wantOK:   true,
+			wantType: "error",
+		},
+		{
+			name:     "audio delta",
+			raw:      fmt.Sprintf(`{"type":"response.output_audio.delta","delta":%q}`, encodedPCM),
+			wantOK:   true,
+			wantType: "audio.delta",
+			wantPCM:  pcm,
+		},
+		{
+			name:   "audio delta with invalid base64 is dropped",
+			raw:    `{"type":"response.output_audio.delta","delta":"not-base64!!"}`,
+			wantOK: false,
+		},
+		{
+			name:     "transcript delta",
+			raw:      `{"type":"response.output_audio_transcript.delta","delta":"hel"}`,
+			wantOK:   true,
+			wantType: "transcript.delta",
+			wantText: "hel",
+		},
+		{
+			name:     "transcript done falls back to the transcript field",
+			raw:      `{"type":"response.output_audio_transcript.done","transcript":"hello world"}`,
+			wantOK:   true,
+			wantType: "transcript.delta",
+			wantText: "hello world",
+		},
+		{
+			name:     "response done",
+			raw:      `{"type":"response.done"}`,
+			wantOK:   true,
+			wantType: "response.done",
+		},
+		{
+			name:     "speech started",
+			raw:      `{"type":"input_audio_buffer.speech_started"}`,
+			wantOK:   true,
+			wantType: "turn.speech_started",
+		},
+		{
+			name:     "speech stopped",
+			raw:      `{"type":"input_audio_buffer.speech_stopped"}`,
+			wantOK:   true,
+			wantType: "turn.speech_stopped",
+		},
+		{
+			name:     "response created",
+			raw:      `{"type":"response.created"}`,
+			wantOK:   true,
+			wantType: "turn.response_created",
+		},
+		{
+			name:   "unrecognised type is dropped",
+			raw:    `{"type":"session.updated"}`,
+			wantOK: false,
+		},
+		{
+			name:   "invalid json is dropped",
+			raw:    `not json`,
+			wantOK: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &openAIProvider{}
+			evt, ok := p.TranslateEvent([]byte(tc.raw))
+			if ok != tc.wantOK {
+				t.Fatalf("want ok=%v, got %v (evt=%+v)", tc.wantOK, ok, evt)
+			}
+			if !ok {
+				return
+			}
+			if evt.Type != tc.wantType {
+				t.Fatalf("want type %q, got %q", tc.wantType, evt.Type)
+			}
+			if tc.wantPCM != nil && string(evt.PCM) != string(tc.wantPCM) {
+				t.Fatalf("want PCM %v, got %v", tc.wantPCM, evt.PCM)
+			}
+			if tc.wantText != "" && evt.Text != tc.wantText {
+				t.Fatalf("want text %q, got %q", tc.wantText, evt.Text)
+			}
+		})
+	}
+}
+
+// ---- geminiProvider.TranslateEvent ----
+
+func geminiModelTurnEvent(b64PCM string) string {
+	return fmt.Sprintf(`{"serverContent":{"modelTurn":{"parts":[{"inlineData":{"data":%q}}]}}}`, b64PCM)
+}
+
+func TestGeminiProvider_TranslateEvent(t *testing.T) {
+	cases := []struct {
+		name     string
+		raw      string
+		wantOK   bool
+		wantType string
+	}{
+		{
+			name:   "invalid json is dropped",
+			raw:    `not json`,
+			wantOK: false,
+		},
+		{
+			name:   "missing serverContent is dropped",
+			raw:    `{"setupComplete":{}}`,
+			wantOK: false,
+		},
+		{
+			name:     "interrupted is a barge-in signal",
+			raw:      `{"serverContent":{"interrupted":true}}`,
+			wantOK:   true,
+			wantType: "turn.speech_started",
+		},
+		{
+			name:     "turn complete",
+			raw:      `{"serverContent":{"turnComplete":true}}`,
+			wantOK:   true,
+			wantType: "response.done",
+		},
+		{
+			name:   "serverContent with no recognised field is dropped",
+			raw:    `{"serverContent":{}}`,
+			wantOK: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &geminiProvider{}
+			evt, ok := p.TranslateEvent([]byte(tc.raw))
+			if ok != tc.wantOK {
+				t.Fatalf("want ok=%v, got %v (evt=%+v)", tc.wantOK, ok, evt)
+			}
+			if ok && evt.Type != tc.wantType {
+				t.Fatalf("want type %q, got %q", tc.wantType, evt.Type)
+			}
+		})
+	}
+}
+
+// TestGeminiProvider_FirstModelTurnChunkOpensTheTurn is the regression test for the
+// bug where Gemini sessions never reached TurnAssistantSpeaking: without an explicit
+// turn.response_created, turnstate.go's barge-in detection was permanently dead for
+// ?provider=gemini.
+func TestGeminiProvider_FirstModelTurnChunkOpensTheTurn(t *testing.T) {
+	pcm := []byte{0x01, 0x02, 0x03}
+	raw := []byte(geminiModelTurnEvent(base64.StdEncoding.EncodeToString(pcm)))
+
+	p := &geminiProvider{}
+	evt, ok := p.TranslateEvent(raw)
+	if !ok {
+		t.Fatal("want ok=true for the first modelTurn chunk")
+	}
+	if evt.Type != "turn.response_created" {
+		t.Fatalf("want the first modelTurn chunk to open the turn, got type %q", evt.Type)
+	}
+	if !p.turnActive {
+		t.Fatal("want turnActive=true after the first modelTurn chunk")
+	}
+	if len(p.pending) != 1 {
+		t.Fatalf("want the audio.delta queued in pending, got %d queued", len(p.pending))
+	}
+
+	// p.pending is non-empty, so Next() returns the queued event without
+	// touching the (nil, in this test) underlying connection.
+	queued, err := p.Next()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if queued.Type != "audio.delta" || string(queued.PCM) != string(pcm) {
+		t.Fatalf("want the queued audio.delta with PCM %v, got %+v", pcm, queued)
+	}
+}
+
+// TestGeminiProvider_SubsequentModelTurnChunksDontReopenTheTurn makes sure we only
+// emit turn.response_created once per turn, not on every audio chunk.
+func TestGeminiProvider_SubsequentModelTurnChunksDontReopenTheTurn(t *testing.T) {
+	p := &geminiProvider{turnActive: true}
+	pcm := []byte{0x09}
+	raw := []byte(geminiModelTurnEvent(base64.StdEncoding.EncodeToString(pcm)))
+
+	evt, ok := p.TranslateEvent(raw)
+	if !ok {
+		t.Fatal("want ok=true")
+	}
+	if evt.Type != "audio.delta" || string(evt.PCM) != string(pcm) {
+		t.Fatalf("want a direct audio.delta once the turn is already active, got %+v", evt)
+	}
+	if len(p.pending) != 0 {
+		t.Fatalf("want nothing queued once the turn is already active, got %d", len(p.pending))
+	}
+}
+
+func TestGeminiProvider_TurnCompleteClearsTurnActive(t *testing.T) {
+	p := &geminiProvider{turnActive: true}
+	if _, ok := p.TranslateEvent([]byte(`{"serverContent":{"turnComplete":true}}`)); !ok {
+		t.Fatal("want ok=true")
+	}
+	if p.turnActive {
+		t.Fatal("want turnActive reset to false once the turn completes")
+	}
+}
+
+func TestGeminiProvider_InterruptedClearsTurnActive(t *testing.T) {
+	p := &geminiProvider{turnActive: true}
+	if _, ok := p.TranslateEvent([]byte(`{"serverContent":{"interrupted":true}}`)); !ok {
+		t.Fatal("want ok=true")
+	}
+	if p.turnActive {
+		t.Fatal("want turnActive reset to false once the assistant is interrupted")
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// recordingEnabled 由環境變數控制，預設關閉，避免每個 session 都寫一堆檔案到磁碟。
+func recordingEnabled() bool {
+	return os.Getenv("RECORD_SESSIONS") == "1"
+}
+
+func recordingsDir() string {
+	if d := os.Getenv("RECORD_DIR"); d != "" {
+		return d
+	}
+	return "recordings"
+}
+
+// recordedEvent 是 events.jsonl 每一行的格式，replay 端照著 AtMs 重現原本的節奏。
+type recordedEvent struct {
+	AtMs    int64  `json:"at_ms"` // 距離 session 開始的毫秒數
+	Dir     string `json:"dir"`   // "in"（client → provider）或 "out"（provider → client）
+	Kind    string `json:"kind"`  // "binary" 或 "text"
+	Payload string `json:"payload"`
+}
+
+// Recorder 把一個 session 的輸入/輸出 PCM、事件、逐字稿寫到磁碟，
+// 方便之後用 /sessions/{id}/replay 重播做 QA。
+type Recorder struct {
+	id        string
+	dir       string
+	startedAt time.Time
+
+	mu         sync.Mutex
+	inputWAV   *wavWriter
+	outputWAV  *wavWriter
+	eventsFile *os.File
+	events     *bufio.Writer
+	transcript strings.Builder
+	closed     bool
+}
+
+// NewRecorder 建立 session 的錄製目錄與檔案；呼叫前應先確認 recordingEnabled()。
+func NewRecorder(id string) (*Recorder, error) {
+	pruneOldRecordings()
+
+	dir := filepath.Join(recordingsDir(), id)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	inputWAV, err := newWAVWriter(filepath.Join(dir, "input.wav"))
+	if err != nil {
+		return nil, err
+	}
+	outputWAV, err := newWAVWriter(filepath.Join(dir, "output.wav"))
+	if err != nil {
+		return nil, err
+	}
+
+	eventsFile, err := os.Create(filepath.Join(dir, "events.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recorder{
+		id:         id,
+		dir:        dir,
+		startedAt:  time.Now(),
+		inputWAV:   inputWAV,
+		outputWAV:  outputWAV,
+		eventsFile: eventsFile,
+		events:     bufio.NewWriter(eventsFile),
+	}, nil
+}
+
+func (rec *Recorder) elapsedMs() int64 {
+	return time.Since(rec.startedAt).Milliseconds()
+}
+
+// RecordInputPCM 記錄 client → provider 的原始 PCM。
+func (rec *Recorder) RecordInputPCM(pcm []byte) {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.closed {
+		return
+	}
+	_ = rec.inputWAV.Write(pcm)
+	rec.writeEventLocked("in", "binary", pcm)
+}
+
+// RecordOutputPCM 記錄 provider → client 的原始 PCM。
+func (rec *Recorder) RecordOutputPCM(pcm []byte) {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.closed {
+		return
+	}
+	_ = rec.outputWAV.Write(pcm)
+	rec.writeEventLocked("out", "binary", pcm)
+}
+
+// RecordText 記錄一則文字事件（錯誤訊息、控制命令等）。
+func (rec *Recorder) RecordText(dir, text string) {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.closed {
+		return
+	}
+	rec.writeEventLocked(dir, "text", []byte(text))
+}
+
+// AppendTranscript 把 OpenAI 的逐字稿片段串接起來，Close 時落地成 transcript.txt。
+func (rec *Recorder) AppendTranscript(text string) {
+	if rec == nil || text == "" {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.transcript.WriteString(text)
+}
+
+func (rec *Recorder) writeEventLocked(dir, kind string, payload []byte) {
+	evt := recordedEvent{
+		AtMs:    rec.elapsedMs(),
+		Dir:     dir,
+		Kind:    kind,
+		Payload: encodeEventPayload(kind, payload),
+	}
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return
+	}
+	rec.events.Write(b)
+	rec.events.WriteByte('\n')
+}
+
+func encodeEventPayload(kind string, payload []byte) string {
+	if kind == "text" {
+		return string(payload)
+	}
+	return base64.StdEncoding.EncodeToString(payload)
+}
+
+// Close 把 WAV header 補上正確的長度、flush 事件檔、寫出逐字稿。
+func (rec *Recorder) Close() {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.closed {
+		return
+	}
+	rec.closed = true
+
+	_ = rec.inputWAV.Close()
+	_ = rec.outputWAV.Close()
+
+	_ = rec.events.Flush()
+	_ = rec.eventsFile.Close()
+
+	if rec.transcript.Len() > 0 {
+		_ = os.WriteFile(filepath.Join(rec.dir, "transcript.txt"), []byte(rec.transcript.String()), 0o644)
+	}
+	log.Printf("📼 recording saved: %s\n", rec.dir)
+}
+
+// pruneOldRecordings 清掉超過 RECORD_MAX_AGE_HOURS 或讓目錄整體超過
+// RECORD_MAX_BYTES 的舊錄音，避免磁碟被慢慢塞滿。
+func pruneOldRecordings() {
+	root := recordingsDir()
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return // 目錄還不存在也沒關係，第一次錄製時會建立
+	}
+
+	maxAgeHours, _ := strconv.Atoi(os.Getenv("RECORD_MAX_AGE_HOURS"))
+	if maxAgeHours <= 0 {
+		maxAgeHours = 24 * 7 // 預設保留一週
+	}
+	cutoff := time.Now().Add(-time.Duration(maxAgeHours) * time.Hour)
+
+	var maxBytes int64 = 2 << 30 // 預設 2GiB
+	if v, err := strconv.ParseInt(os.Getenv("RECORD_MAX_BYTES"), 10, 64); err == nil && v > 0 {
+		maxBytes = v
+	}
+
+	type dirInfo struct {
+		path    string
+		modTime time.Time
+		size    int64
+	}
+	var dirs []dirInfo
+	var total int64
+
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		path := filepath.Join(root, e.Name())
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		size := dirSize(path)
+		total += size
+		dirs = append(dirs, dirInfo{path: path, modTime: info.ModTime(), size: size})
+	}
+
+	for _, d := range dirs {
+		if d.modTime.Before(cutoff) {
+			log.Printf("🧹 pruning expired recording: %s\n", d.path)
+			_ = os.RemoveAll(d.path)
+			total -= d.size
+		}
+	}
+
+	// 還是超過總容量限制的話，從最舊的開始刪。
+	if total > maxBytes {
+		for i := range dirs {
+			for j := i + 1; j < len(dirs); j++ {
+				if dirs[j].modTime.Before(dirs[i].modTime) {
+					dirs[i], dirs[j] = dirs[j], dirs[i]
+				}
+			}
+		}
+		for _, d := range dirs {
+			if total <= maxBytes {
+				break
+			}
+			if _, err := os.Stat(d.path); err != nil {
+				continue // 已經在上面被年齡規則刪掉了
+			}
+			log.Printf("🧹 pruning recording to stay under quota: %s\n", d.path)
+			_ = os.RemoveAll(d.path)
+			total -= d.size
+		}
+	}
+}
+
+func dirSize(path string) int64 {
+	var total int64
+	_ = filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// ---- replay ----
+
+// registerReplayRoute 加上 GET /sessions/{id}/replay，把存好的 events.jsonl
+// 依照原始的時間間隔重新送一次，方便除錯或回歸測試。錄音裡可能有使用者的逐字稿/音訊，
+// 所以跟 /admin/* 一樣需要 X-Admin-Token，而且 id 必須先過 validSessionID，
+// 避免被拿去拼出 recordingsDir() 以外的路徑。
+func registerReplayRoute(mux *http.ServeMux) {
+	mux.HandleFunc("/sessions/", func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		rest := strings.TrimPrefix(r.URL.Path, "/sessions/")
+		const suffix = "/replay"
+		if !strings.HasSuffix(rest, suffix) {
+			http.NotFound(w, r)
+			return
+		}
+		id := strings.TrimSuffix(rest, suffix)
+		if !validSessionID(id) {
+			http.Error(w, "invalid session id", http.StatusBadRequest)
+			return
+		}
+
+		f, err := os.Open(filepath.Join(recordingsDir(), id, "events.jsonl"))
+		if err != nil {
+			http.Error(w, "recording not found", http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Println("replay upgrade error:", err)
+			return
+		}
+		defer conn.Close()
+
+		replayEvents(conn, f)
+	})
+}
+
+func replayEvents(conn *websocket.Conn, f *os.File) {
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 8*1024*1024)
+
+	var lastAtMs int64
+	first := true
+
+	for scanner.Scan() {
+		var evt recordedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &evt); err != nil {
+			continue
+		}
+
+		if !first {
+			if delay := time.Duration(evt.AtMs-lastAtMs) * time.Millisecond; delay > 0 {
+				time.Sleep(delay)
+			}
+		}
+		first = false
+		lastAtMs = evt.AtMs
+
+		var err error
+		if evt.Kind == "binary" {
+			pcm, decErr := base64.StdEncoding.DecodeString(evt.Payload)
+			if decErr != nil {
+				continue
+			}
+			err = conn.WriteMessage(websocket.BinaryMessage, pcm)
+		} else {
+			err = conn.WriteMessage(websocket.TextMessage, []byte(evt.Payload))
+		}
+		if err != nil {
+			log.Println("replay write error:", err)
+			return
+		}
+	}
+	_ = conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"type":"replay.done"}`)))
+}
+
+// ---- minimal WAV writer (16-bit PCM, mono, rateHz) ----
+
+type wavWriter struct {
+	f           *os.File
+	dataBytes   int64
+	headerBytes int64
+}
+
+func newWAVWriter(path string) (*wavWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &wavWriter{f: f}
+	if err := w.writeHeader(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *wavWriter) writeHeader(dataLen int64) error {
+	var header [44]byte
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(36+dataLen))
+	copy(header[8:12], "WAVE")
+	copy(header[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16) // PCM fmt chunk size
+	binary.LittleEndian.PutUint16(header[20:22], 1)  // PCM format
+	binary.LittleEndian.PutUint16(header[22:24], uint16(ch))
+	binary.LittleEndian.PutUint32(header[24:28], uint32(rateHz))
+	byteRate := rateHz * ch * 2
+	binary.LittleEndian.PutUint32(header[28:32], uint32(byteRate))
+	binary.LittleEndian.PutUint16(header[32:34], uint16(ch*2)) // block align
+	binary.LittleEndian.PutUint16(header[34:36], 16)           // bits per sample
+	copy(header[36:40], "data")
+	binary.LittleEndian.PutUint32(header[40:44], uint32(dataLen))
+
+	if _, err := w.f.WriteAt(header[:], 0); err != nil {
+		return err
+	}
+	w.headerBytes = 44
+	return nil
+}
+
+func (w *wavWriter) Write(pcm []byte) error {
+	if _, err := w.f.WriteAt(pcm, w.headerBytes+w.dataBytes); err != nil {
+		return err
+	}
+	w.dataBytes += int64(len(pcm))
+	return nil
+}
+
+func (w *wavWriter) Close() error {
+	if err := w.writeHeader(w.dataBytes); err != nil {
+		return err
+	}
+	return w.f.Close()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestRecorder(t *testing.T) *Recorder {
+	t.Helper()
+	t.Setenv("RECORD_DIR", t.TempDir())
+
+	rec, err := NewRecorder("test-session")
+	if err != nil {
+		t.Fatalf("NewRecorder: %v", err)
+	}
+	return rec
+}
+
+func wavDataLen(t *testing.T, path string) uint32 {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read wav: %v", err)
+	}
+	if len(b) < 44 {
+		t.Fatalf("wav file too short to have a header: %d bytes", len(b))
+	}
+	return binary.LittleEndian.Uint32(b[40:44])
+}
+
+func TestRecorder_CloseFlushesWAVHeadersAndTranscript(t *testing.T) {
+	rec := newTestRecorder(t)
+
+	inPCM := []byte{0x01, 0x02, 0x03, 0x04}
+	outPCM := []byte{0x05, 0x06}
+	rec.RecordInputPCM(inPCM)
+	rec.RecordOutputPCM(outPCM)
+	rec.AppendTranscript("hello ")
+	rec.AppendTranscript("world")
+	rec.Close()
+
+	if got := wavDataLen(t, filepath.Join(rec.dir, "input.wav")); got != uint32(len(inPCM)) {
+		t.Fatalf("input.wav dataLen = %d, want %d", got, len(inPCM))
+	}
+	if got := wavDataLen(t, filepath.Join(rec.dir, "output.wav")); got != uint32(len(outPCM)) {
+		t.Fatalf("output.wav dataLen = %d, want %d", got, len(outPCM))
+	}
+
+	transcript, err := os.ReadFile(filepath.Join(rec.dir, "transcript.txt"))
+	if err != nil {
+		t.Fatalf("read transcript.txt: %v", err)
+	}
+	if string(transcript) != "hello world" {
+		t.Fatalf("transcript.txt = %q, want %q", transcript, "hello world")
+	}
+}
+
+func TestRecorder_EventsJSONLRecordsInOrder(t *testing.T) {
+	rec := newTestRecorder(t)
+
+	rec.RecordInputPCM([]byte{0xAA})
+	rec.RecordText("out", "some error")
+	rec.Close()
+
+	f, err := os.Open(filepath.Join(rec.dir, "events.jsonl"))
+	if err != nil {
+		t.Fatalf("open events.jsonl: %v", err)
+	}
+	defer f.Close()
+
+	var events []recordedEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var evt recordedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &evt); err != nil {
+			t.Fatalf("unmarshal event line: %v", err)
+		}
+		events = append(events, evt)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("want 2 events, got %d: %+v", len(events), events)
+	}
+	if events[0].Dir != "in" || events[0].Kind != "binary" {
+		t.Fatalf("want first event {dir:in kind:binary}, got %+v", events[0])
+	}
+	if events[1].Dir != "out" || events[1].Kind != "text" || events[1].Payload != "some error" {
+		t.Fatalf("want second event {dir:out kind:text payload:\"some error\"}, got %+v", events[1])
+	}
+}
+
+func TestRecorder_CloseIsIdempotent(t *testing.T) {
+	rec := newTestRecorder(t)
+	rec.RecordInputPCM([]byte{0x01})
+	rec.Close()
+	rec.Close() // must not panic or double-write the header
+
+	if got := wavDataLen(t, filepath.Join(rec.dir, "input.wav")); got != 1 {
+		t.Fatalf("input.wav dataLen = %d, want 1", got)
+	}
+}
+
+func TestRecorder_MethodsAreNilSafe(t *testing.T) {
+	var rec *Recorder
+	rec.RecordInputPCM([]byte{0x01})
+	rec.RecordOutputPCM([]byte{0x01})
+	rec.RecordText("in", "noop")
+	rec.AppendTranscript("noop")
+	rec.Close() // none of the above should panic on a nil *Recorder
+}
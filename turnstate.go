@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TurnState 是一個 session 目前所在的對話輪次狀態。
+type TurnState string
+
+const (
+	TurnIdle              TurnState = "idle"
+	TurnUserSpeaking      TurnState = "user_speaking"
+	TurnAssistantSpeaking TurnState = "assistant_speaking"
+	TurnInterrupted       TurnState = "interrupted"
+)
+
+// TurnControlEvent 是推給 client 的正規化控制訊號，讓前端不用認識 OpenAI 的事件名稱
+// 就能知道「現在該打斷播放了」或「目前輪到誰講話」。
+type TurnControlEvent struct {
+	Type  string `json:"type"` // "turn.state" 或 "playback.flush"
+	State string `json:"state,omitempty"`
+}
+
+// TurnStateMachine 依照 provider 正規化過的事件（speech_started/stopped、
+// response_created、response.done）推進狀態，並在使用者講話打斷 assistant
+// 回覆時標記需要 barge-in。每個 session 一個instance。
+type TurnStateMachine struct {
+	mu            sync.Mutex
+	state         TurnState
+	turnStartedAt time.Time
+}
+
+func newTurnStateMachine() *TurnStateMachine {
+	return &TurnStateMachine{state: TurnIdle}
+}
+
+func (m *TurnStateMachine) State() TurnState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+// HandleEvent 吃一個正規化事件的 Type，回傳 bargeIn（這次要不要打斷 assistant）
+// 以及要不要推一個狀態變化給 client（state 沒變就回 nil）。
+func (m *TurnStateMachine) HandleEvent(evtType string) (bargeIn bool, control *TurnControlEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prev := m.state
+
+	switch evtType {
+	case "turn.speech_started":
+		if m.state == TurnAssistantSpeaking {
+			bargeIn = true
+			m.state = TurnInterrupted
+			turnMetrics.interruptions.Inc()
+		} else {
+			m.state = TurnUserSpeaking
+		}
+
+	case "turn.speech_stopped":
+		if m.state == TurnUserSpeaking {
+			m.state = TurnIdle
+		}
+
+	case "turn.response_created":
+		m.state = TurnAssistantSpeaking
+		m.turnStartedAt = time.Now()
+
+	case "response.done":
+		if !m.turnStartedAt.IsZero() {
+			turnMetrics.turnLatency.Observe(time.Since(m.turnStartedAt).Seconds())
+			m.turnStartedAt = time.Time{}
+		}
+		m.state = TurnIdle
+
+	default:
+		return false, nil
+	}
+
+	if m.state != prev {
+		control = &TurnControlEvent{Type: "turn.state", State: string(m.state)}
+	}
+	return bargeIn, control
+}
+
+// audioBufferClearer 是給支援 output_audio_buffer.clear 的 provider（目前只有 OpenAI）
+// 實作的額外能力；用 type assertion 取得，其他 provider 不實作也沒關係。
+type audioBufferClearer interface {
+	ClearOutputBuffer() error
+}
+
+func marshalControlEvent(evt *TurnControlEvent) []byte {
+	b, _ := json.Marshal(evt)
+	return b
+}
+
+// ---- Prometheus metrics ----
+
+type turnMetricsRegistry struct {
+	interruptions prometheus.Counter
+	turnLatency   prometheus.Histogram
+}
+
+var turnMetrics = newTurnMetricsRegistry()
+
+func newTurnMetricsRegistry() *turnMetricsRegistry {
+	m := &turnMetricsRegistry{
+		interruptions: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "realtime_proxy_barge_in_total",
+			Help: "Number of times user speech interrupted an in-progress assistant response.",
+		}),
+		turnLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "realtime_proxy_turn_latency_seconds",
+			Help:    "Time from response.created to response.done for a single turn.",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+	prometheus.MustRegister(m.interruptions, m.turnLatency)
+	return m
+}
@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func TestTurnStateMachine_UserSpeechWithoutActiveResponse(t *testing.T) {
+	m := newTurnStateMachine()
+
+	bargeIn, control := m.HandleEvent("turn.speech_started")
+	if bargeIn {
+		t.Fatal("want no barge-in when assistant isn't speaking")
+	}
+	if control == nil || control.State != string(TurnUserSpeaking) {
+		t.Fatalf("want control event for user_speaking, got %+v", control)
+	}
+	if got := m.State(); got != TurnUserSpeaking {
+		t.Fatalf("want state %q, got %q", TurnUserSpeaking, got)
+	}
+}
+
+func TestTurnStateMachine_SpeechStoppedReturnsToIdle(t *testing.T) {
+	m := newTurnStateMachine()
+	m.HandleEvent("turn.speech_started")
+
+	bargeIn, control := m.HandleEvent("turn.speech_stopped")
+	if bargeIn {
+		t.Fatal("speech_stopped should never trigger a barge-in")
+	}
+	if control == nil || control.State != string(TurnIdle) {
+		t.Fatalf("want control event for idle, got %+v", control)
+	}
+}
+
+func TestTurnStateMachine_BargeInWhileAssistantSpeaking(t *testing.T) {
+	m := newTurnStateMachine()
+	m.HandleEvent("turn.response_created")
+
+	bargeIn, control := m.HandleEvent("turn.speech_started")
+	if !bargeIn {
+		t.Fatal("want barge-in when user speaks over an active assistant response")
+	}
+	if control == nil || control.State != string(TurnInterrupted) {
+		t.Fatalf("want control event for interrupted, got %+v", control)
+	}
+	if got := m.State(); got != TurnInterrupted {
+		t.Fatalf("want state %q, got %q", TurnInterrupted, got)
+	}
+}
+
+func TestTurnStateMachine_ResponseDoneReturnsToIdle(t *testing.T) {
+	m := newTurnStateMachine()
+	m.HandleEvent("turn.response_created")
+
+	bargeIn, control := m.HandleEvent("response.done")
+	if bargeIn {
+		t.Fatal("response.done should never trigger a barge-in")
+	}
+	if control == nil || control.State != string(TurnIdle) {
+		t.Fatalf("want control event for idle, got %+v", control)
+	}
+}
+
+func TestTurnStateMachine_UnknownEventIsIgnored(t *testing.T) {
+	m := newTurnStateMachine()
+
+	bargeIn, control := m.HandleEvent("some.unrelated.event")
+	if bargeIn || control != nil {
+		t.Fatalf("want no-op for unknown event, got bargeIn=%v control=%+v", bargeIn, control)
+	}
+	if got := m.State(); got != TurnIdle {
+		t.Fatalf("want state to stay %q, got %q", TurnIdle, got)
+	}
+}
+
+func TestTurnStateMachine_NoStateChangeMeansNoControlEvent(t *testing.T) {
+	m := newTurnStateMachine()
+	m.HandleEvent("turn.speech_started")
+
+	// already user_speaking, speech_started again shouldn't fire (falls to the else branch,
+	// state stays user_speaking so prev == state).
+	_, control := m.HandleEvent("turn.speech_started")
+	if control != nil {
+		t.Fatalf("want nil control when state doesn't change, got %+v", control)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import "errors"
+
+// EBML element IDs this demuxer cares about (values include the VINT length marker,
+// written the way the Matroska/WebM spec documents them).
+const (
+	ebmlIDSegment     = 0x18538067
+	ebmlIDCluster     = 0x1F43B675
+	ebmlIDSimpleBlock = 0xA3
+)
+
+var errTruncatedWebM = errors.New("truncated webm chunk: incomplete EBML element")
+
+// webmPacketExtractor 把 Chrome MediaRecorder 吐出來的 audio/webm;codecs=opus chunk
+// 解開，取出裡面 Cluster > SimpleBlock 包的 Opus packet。跟 oggPacketExtractor 的
+// 角色一樣，只是容器格式換成 EBML/Matroska 的一個極簡子集：
+//   - 只認得 Segment/Cluster 這兩層 container（兩者常見都是 unknown size 的 streaming
+//     寫法），其餘 top-level element（EBML header、Tracks、Info、Cues...）整個按已知
+//     size 跳過，不解析內容。
+//   - 只抽 SimpleBlock，不支援 lacing 或 BlockGroup——MediaRecorder 單軌音訊輸出一律是
+//     no-lacing 的 SimpleBlock，這個限制在它的輸出下不會觸發。
+//   - 跟 oggPacketExtractor 一樣不處理跨 chunk 延續的 element：一個 chunk 必須包含
+//     完整的 element 才解得出來，否則回傳 errTruncatedWebM。MediaRecorder 用
+//     timeslice 切出來的 blob 實務上都是落在 Cluster 邊界上，不會觸發這個限制。
+type webmPacketExtractor struct{}
+
+// extract 回傳 chunk 裡面的音訊 packet（Opus payload，未解碼）。
+func (e *webmPacketExtractor) extract(chunk []byte) ([][]byte, error) {
+	var packets [][]byte
+	pos := 0
+
+	for pos < len(chunk) {
+		id, idLen, ok := readEBMLID(chunk[pos:])
+		if !ok {
+			return nil, errTruncatedWebM
+		}
+		size, sizeLen, unknown, ok := readEBMLSize(chunk[pos+idLen:])
+		if !ok {
+			return nil, errTruncatedWebM
+		}
+		bodyStart := pos + idLen + sizeLen
+
+		switch id {
+		case ebmlIDSegment, ebmlIDCluster:
+			// Container：內容就是緊接著的 bytes。size 通常是 streaming 用的
+			// unknown-size 標記，這種情況下我們就把它攤平，繼續解析它的子
+			// element，直到這個 chunk 被吃完為止。
+			pos = bodyStart
+
+		case ebmlIDSimpleBlock:
+			if unknown {
+				return nil, errTruncatedWebM
+			}
+			if bodyStart+int(size) > len(chunk) {
+				return nil, errTruncatedWebM
+			}
+			pkt, err := simpleBlockPayload(chunk[bodyStart : bodyStart+int(size)])
+			if err != nil {
+				return nil, err
+			}
+			if pkt != nil {
+				packets = append(packets, pkt)
+			}
+			pos = bodyStart + int(size)
+
+		default:
+			// 不認識、也不需要的 element：按 size 整塊跳過。
+			if unknown {
+				return nil, errTruncatedWebM
+			}
+			pos = bodyStart + int(size)
+			if pos > len(chunk) {
+				return nil, errTruncatedWebM
+			}
+		}
+	}
+	return packets, nil
+}
+
+// simpleBlockPayload 拆 SimpleBlock 的內容：track number（VINT）+ 2 bytes 相對
+// timecode（這裡用不到）+ 1 byte flags + frame data。flags 裡的 lacing bits
+// 不是 00 就代表這個 block 不是我們支援的單一 frame 格式。
+func simpleBlockPayload(body []byte) ([]byte, error) {
+	_, n, _, ok := readEBMLSize(body) // track number 用跟 element size 一樣的 VINT 編碼
+	if !ok || n+3 > len(body) {
+		return nil, errTruncatedWebM
+	}
+	flags := body[n+2]
+	if flags&0x06 != 0 {
+		return nil, errors.New("webm demux: SimpleBlock lacing is not supported")
+	}
+	return body[n+3:], nil
+}
+
+// readEBMLID 讀一個 EBML element ID（1~4 bytes，保留原本的 length marker，
+// 就是 spec 裡慣用的十六進位寫法，例如 Segment = 0x18538067）。
+func readEBMLID(b []byte) (id uint32, n int, ok bool) {
+	length := vintLength(b)
+	if length == 0 || length > 4 || len(b) < length {
+		return 0, 0, false
+	}
+	for i := 0; i < length; i++ {
+		id = id<<8 | uint32(b[i])
+	}
+	return id, length, true
+}
+
+// readEBMLSize 讀一個 EBML size VINT（1~8 bytes，已經去掉 length marker）。
+// unknown=true 代表這是 streaming 常見的「size 未知」標記（所有資料位元都是 1）。
+func readEBMLSize(b []byte) (size uint64, n int, unknown bool, ok bool) {
+	length := vintLength(b)
+	if length == 0 || length > 8 || len(b) < length {
+		return 0, 0, false, false
+	}
+	v := uint64(b[0]) &^ (uint64(1) << uint(8-length))
+	for i := 1; i < length; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	max := uint64(1)<<uint(7*length) - 1
+	return v, length, v == max, true
+}
+
+// vintLength 回傳 VINT 的總長度：第一個非零 bit 的位置決定（leading marker）。
+func vintLength(b []byte) int {
+	if len(b) == 0 {
+		return 0
+	}
+	first := b[0]
+	for i := 7; i >= 0; i-- {
+		if first&(1<<uint(i)) != 0 {
+			return 8 - i
+		}
+	}
+	return 0 // 整個 byte 都是 0，不是合法的 VINT 開頭
+}
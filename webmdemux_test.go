@@ -0,0 +1,151 @@
+package main
+
+import "testing"
+
+// vintBytes 依照 EBML VINT 規則編碼一個值：length 決定用幾個 byte，
+// 第一個 byte 的前導 1 是 length marker。v 必須在 (length*7) bits 裝得下。
+func vintBytes(length int, v uint64) []byte {
+	b := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+	b[0] |= 1 << uint(8-length)
+	return b
+}
+
+func ebmlID(length int, id uint32) []byte {
+	b := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		b[i] = byte(id)
+		id >>= 8
+	}
+	return b
+}
+
+// simpleBlock 組一個 no-lacing、track number 1 的 SimpleBlock element（含自己的
+// ID/size header），payload 就是裡面包的 Opus packet。
+func simpleBlock(payload []byte) []byte {
+	body := []byte{0x81, 0x00, 0x00, 0x00} // track number VINT(1)=1, timecode=0, flags=0 (no lacing)
+	body = append(body, payload...)
+	el := ebmlID(1, ebmlIDSimpleBlock)
+	el = append(el, vintBytes(1, uint64(len(body)))...)
+	el = append(el, body...)
+	return el
+}
+
+// cluster 把一串 child element（例如一個或多個 simpleBlock）包進一個 known-size Cluster。
+func cluster(children ...[]byte) []byte {
+	var body []byte
+	for _, c := range children {
+		body = append(body, c...)
+	}
+	el := ebmlID(4, ebmlIDCluster)
+	el = append(el, vintBytes(4, uint64(len(body)))...)
+	el = append(el, body...)
+	return el
+}
+
+// unknownSizeSegment 包一個 unknown-size（streaming）的 Segment，內容是後面接的 bytes。
+func unknownSizeSegment(children ...[]byte) []byte {
+	el := ebmlID(4, ebmlIDSegment)
+	el = append(el, 0xFF) // 1-byte VINT size, all data bits set = unknown
+	for _, c := range children {
+		el = append(el, c...)
+	}
+	return el
+}
+
+func TestWebmPacketExtractor_SingleSimpleBlockInCluster(t *testing.T) {
+	e := &webmPacketExtractor{}
+	payload := []byte{0x01, 0x02, 0x03}
+	chunk := unknownSizeSegment(cluster(simpleBlock(payload)))
+
+	packets, err := e.extract(chunk)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(packets) != 1 || string(packets[0]) != string(payload) {
+		t.Fatalf("want [%v], got %v", payload, packets)
+	}
+}
+
+func TestWebmPacketExtractor_MultipleSimpleBlocksAcrossClusters(t *testing.T) {
+	e := &webmPacketExtractor{}
+	p1 := []byte{0xAA}
+	p2 := []byte{0xBB, 0xCC}
+	chunk := unknownSizeSegment(
+		cluster(simpleBlock(p1)),
+		cluster(simpleBlock(p2)),
+	)
+
+	packets, err := e.extract(chunk)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(packets) != 2 || string(packets[0]) != string(p1) || string(packets[1]) != string(p2) {
+		t.Fatalf("want [%v %v], got %v", p1, p2, packets)
+	}
+}
+
+func TestWebmPacketExtractor_SkipsUnknownTopLevelElements(t *testing.T) {
+	e := &webmPacketExtractor{}
+	// A made-up top-level element (e.g. standing in for Tracks/Info/Cues) that
+	// should just be skipped over by its known size, not treated as an error.
+	skippable := append(ebmlID(4, 0x1654AE6B), vintBytes(1, 2)...)
+	skippable = append(skippable, 0x00, 0x00)
+
+	payload := []byte{0x42}
+	chunk := append(skippable, unknownSizeSegment(cluster(simpleBlock(payload)))...)
+
+	packets, err := e.extract(chunk)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(packets) != 1 || string(packets[0]) != string(payload) {
+		t.Fatalf("want [%v], got %v", payload, packets)
+	}
+}
+
+func TestWebmPacketExtractor_TruncatedClusterIsRejected(t *testing.T) {
+	e := &webmPacketExtractor{}
+	block := simpleBlock([]byte{0x01, 0x02, 0x03})
+	full := cluster(block)
+	chunk := unknownSizeSegment(full[:len(full)-1]) // chop off the last payload byte
+
+	if _, err := e.extract(chunk); err == nil {
+		t.Fatal("want an error for a Cluster whose declared size overruns the chunk")
+	}
+}
+
+func TestWebmPacketExtractor_SimpleBlockWithLacingIsRejected(t *testing.T) {
+	e := &webmPacketExtractor{}
+	body := []byte{0x81, 0x00, 0x00, 0x06} // flags=0x06 sets the lacing bits
+	body = append(body, 0x01, 0x02)
+	el := ebmlID(1, ebmlIDSimpleBlock)
+	el = append(el, vintBytes(1, uint64(len(body)))...)
+	el = append(el, body...)
+	chunk := unknownSizeSegment(cluster(el))
+
+	if _, err := e.extract(chunk); err == nil {
+		t.Fatal("want an error for a laced SimpleBlock, which this extractor doesn't support")
+	}
+}
+
+func TestWebmPacketExtractor_EmptyChunkReturnsNoPackets(t *testing.T) {
+	e := &webmPacketExtractor{}
+	packets, err := e.extract(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(packets) != 0 {
+		t.Fatalf("want no packets for an empty chunk, got %v", packets)
+	}
+}
+
+func TestWebmPacketExtractor_TruncatedElementHeaderIsRejected(t *testing.T) {
+	e := &webmPacketExtractor{}
+	if _, err := e.extract([]byte{0x1F, 0x43}); err == nil {
+		t.Fatal("want an error for a chunk that cuts off mid element-header")
+	}
+}